@@ -0,0 +1,33 @@
+package atlimiter
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// - is a key of tokens accumulator in context.
+type accountingKey struct{}
+
+// - returns context that accumulates tokens consumed by limiter methods receiving it.
+//
+// Wait, WaitN, AllowCtx, AllowQueued and DoOrFallback add tokens they take to the accumulator, so at the end of request
+// ConsumedFromContext reports how much budget it spent. Accumulator is shared by derived contexts
+// and is safe for concurrent use when request fans out.
+func WithAccounting(ctx context.Context) context.Context {
+	return context.WithValue(ctx, accountingKey{}, new(atomic.Uint64))
+}
+
+// - returns quantity of tokens consumed under ctx, zero if ctx has no accumulator.
+func ConsumedFromContext(ctx context.Context) uint64 {
+	if consumed, ok := ctx.Value(accountingKey{}).(*atomic.Uint64); ok {
+		return consumed.Load()
+	}
+	return 0
+}
+
+// - is a private function that adds consumed tokens to ctx's accumulator if there is one.
+func addConsumed(ctx context.Context, tokensCount uint64) {
+	if consumed, ok := ctx.Value(accountingKey{}).(*atomic.Uint64); ok {
+		consumed.Add(tokensCount)
+	}
+}
@@ -0,0 +1,45 @@
+package atlimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccounting(t *testing.T) {
+	first := NewLimiter(100, 1.0)
+	second := NewLimiter(100, 1.0)
+	ctx := WithAccounting(context.Background())
+
+	if err := first.WaitN(ctx, 5); err != nil {
+		t.Fatalf("WaitN returned error: %v", err)
+	}
+	if err := second.Wait(ctx); err != nil {
+		t.Fatalf("Wait returned error: %v", err)
+	}
+	if err := first.DoOrFallback(ctx, time.Second, func() error { return nil }, func() error { return nil }); err != nil {
+		t.Fatalf("DoOrFallback returned error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			second.WaitN(ctx, 2)
+		}()
+	}
+	wg.Wait()
+
+	if !NewLimiter(100, 1.0, WithQueue(1)).AllowQueued(ctx) {
+		t.Fatal("AllowQueued should allow with tokens")
+	}
+
+	if consumed := ConsumedFromContext(ctx); consumed != 28 {
+		t.Errorf("Expected 28 consumed tokens, got %d", consumed)
+	}
+	if consumed := ConsumedFromContext(context.Background()); consumed != 0 {
+		t.Errorf("Context without accounting should report 0, got %d", consumed)
+	}
+}
@@ -5,10 +5,31 @@ package atlimiter
 
 // Only standart libraries
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/bits"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// - is returned when requested quantity of tokens can never be satisfied because it exceeds capacity.
+var ErrExceedsCapacity = errors.New("atlimiter: tokens count exceeds capacity")
+
+// - is returned by blocking methods when gate set by WithGate denies the request.
+var ErrGateClosed = errors.New("atlimiter: gate is closed")
+
+// - is a common interface of limiters, implemented by ATLimiter and LeakyBucketLimiter.
+type Limiter interface {
+	// Allow checks and allows one request
+	Allow() bool
+	// TryAllow checks and allows N = tokensCount of requests
+	TryAllow(tokensCount uint64) bool
+}
+
 // - is a base stucture that provides all operations.
 type ATLimiter struct {
 	// Max quantity of requests per second - base parameter of rate limiter
@@ -16,132 +37,1084 @@ type ATLimiter struct {
 	// Max burst of requests - is an option that allows to increase the speed for a limited period of time
 	// even if a lower speed is specified in the max-limit parameter in the queue settings.
 	capacity uint64
-	// The current number of tokens in the token container.
-	// Tokens are generated every second and spent on request at a one-to-one ratio.
-	tokens atomic.Uint64
-	// Last refill - is a previous token replenishment in unix nanoseconds
-	lastRefill atomic.Int64
+	// Rounding of fractional capacity, truncation by default
+	rounding CapacityRounding
+	// Capacity increase multiplier in float64 bits, as it was passed to constructor or SetMaxRPS
+	factorBits uint64
+	// Storage of tokens and last refill, by default it's the in-struct local store
+	store TokenStore
+	// In-struct atomics used as store unless another one is set by WithTokenStore
+	local localStore
+	// Smoothing mode keeps capacity equal to one, so requests are spaced evenly without bursts
+	smoothing bool
+	// Max quantity of tokens added by one refill, zero means no cap
+	maxRefillStep uint64
+	// Interval of fixed windows that reset tokens to capacity instead of continuous refill, zero means no windows
+	windowReset time.Duration
+	// Quantity of tokens ordinary requests can take from full container, zero means no soft limit
+	softLimit uint64
+	// Quantity of allowed requests since creation
+	allowed atomic.Uint64
+	// Quantity of denied requests since creation
+	denied atomic.Uint64
+	// Time of last denial in nanoseconds of limiter's clock, zero if there were no denials
+	lastDenial atomic.Int64
+	// Predicate that vetoes requests before tokens are checked, nil means no gate
+	gate func() bool
+	// Policy of deciding on requests at zero tokens and debt of extra grant made by OverflowGrace
+	overflowPolicy OverflowPolicy
+	debt           atomic.Uint64
+	// Hooks called when tokens run out and when they appear again
+	onEmpty     func()
+	onRecovered func()
+	// Warning threshold of grants per second and hook called when it's exceeded
+	softRPS      uint64
+	onSoftExceed func()
+	// Start of the second when soft rate hook was called last time
+	softNotified atomic.Int64
+	// Quantity of panics recovered in user-supplied hooks
+	hookPanics atomic.Uint64
+	// Strict hooks mode re-panics instead of recovering
+	strictHooks bool
+	// Tokens granted during current and previous second, counted only if trackGrants is set
+	grants      grantWindow
+	trackGrants atomic.Bool
+	// Quantity of refills performed and skipped because another goroutine won CAS of last refill
+	refillsPerformed atomic.Uint64
+	refillsSkipped   atomic.Uint64
+	// Source of current time, nil means time.Now
+	clock func() time.Time
+	// Source of timers matching clock, nil means time.After
+	timer func(d time.Duration) <-chan time.Time
+	// Wall-clock mode reads wall time instead of monotonic time elapsed since epoch
+	wallClock bool
+	// Source of wall time without injected clock, nil means time.Now. Monotonic mode reads it only at creation
+	wallSource func() time.Time
+	// Creation time of limiter, base of monotonic clock
+	epoch      time.Time
+	epochNanos int64
+	// Closed by Close to stop background goroutines
+	done chan struct{}
+	// Lifecycle state of background goroutines, changed under lifecycleMu
+	lifecycleMu sync.Mutex
+	started     atomic.Bool
+	closed      atomic.Bool
+	// Background goroutines launched by Start
+	workers sync.WaitGroup
+	// Quantity of tokens in container after creation, used if hasInitialTokens is set
+	initialTokens    uint64
+	hasInitialTokens bool
+	// Closed and replaced by SetMaxRPS to wake up goroutines blocked in Wait
+	reconfigured atomic.Pointer[chan struct{}]
+	// FIFO queue of waiters used in fair waiting mode
+	fairWaiting bool
+	waitMu      sync.Mutex
+	waiters     []*waiter
+	// Max quantity of requests waiting in AllowQueued and quantity of them now
+	queueMaxLen int
+	queued      atomic.Int64
+	// Settings of dynamic rate sampling
+	rateInterval time.Duration
+	rateFn       func() uint64
+	// Target fraction of denied requests for capacity auto-tuning, used if autoCapacity is set
+	autoCapacity   bool
+	targetDenyRate float64
+	// Bounds of auto-tuned capacity
+	minCapacity uint64
+	maxCapacity uint64
+	// Settings of periodic summary log
+	logInterval time.Duration
+	logger      *slog.Logger
+	// Quantity of granted tokens, counted only for periodic summary log
+	grantedTokens atomic.Uint64
+	// Ring buffer of recent denials, nil unless WithDenialSampling is set
+	denials *denialRing
+	// Recently seen request IDs for AllowOnce, created on first use
+	idempotency     *idempotencyCache
+	idempotencyOnce sync.Once
+	// Settings of idempotency cache
+	idempotencyTTL        time.Duration
+	idempotencyMaxEntries int
+}
+
+// - is a point-in-time copy of limiter's counters.
+type Stats struct {
+	// Quantity of allowed requests
+	Allowed uint64
+	// Quantity of denied requests
+	Denied uint64
+	// Quantity of refills that generated tokens
+	RefillsPerformed uint64
+	// Quantity of refills skipped because another goroutine refilled first
+	RefillsSkipped uint64
+	// Quantity of available tokens
+	Tokens uint64
+	// Max quantity of tokens
+	Capacity uint64
+	// Quantity of panics recovered in user-supplied hooks
+	HookPanics uint64
+	// Time of last denial, zero if there were no denials
+	LastDenialAt time.Time
+	// Quantity of requests waiting in AllowQueued
+	QueueLen uint64
 }
 
 // - is a constructor of atlimiter copies.
 //
 // Takes maxRPS, the maximum number of requests per second, as a parameter.
 // Takes capacityFactor, capacity increase multiplier in float64 number, as a parameter.
-func NewLimiter(maxRPS uint64, capacityFactor float64) *ATLimiter {
-	if capacityFactor < 1.0 {
-		capacityFactor = 1.0
+// Fractional capacity maxRPS * capacityFactor is truncated unless WithCapacityRounding is set.
+// Takes opts, optional settings of limiter, as a variadic parameter.
+func NewLimiter(maxRPS uint64, capacityFactor float64, opts ...Option) *ATLimiter {
+	l := &ATLimiter{
+		maxRPS:     maxRPS,
+		factorBits: math.Float64bits(capacityFactor),
+		done:       make(chan struct{}),
 	}
 
-	capacity := max(max(uint64(float64(maxRPS)*capacityFactor), 1), maxRPS)
+	for _, opt := range opts {
+		opt(l)
+	}
 
-	now := time.Now().UnixNano()
-	l := &ATLimiter{
-		maxRPS:   maxRPS,
-		capacity: capacity,
+	if l.capacity == 0 {
+		l.capacity = calculateCapacity(maxRPS, capacityFactor, l.rounding)
 	}
+	if l.smoothing {
+		l.capacity = 1
+	}
+
+	reconfigured := make(chan struct{})
+	l.reconfigured.Store(&reconfigured)
 
-	l.tokens.Store(capacity)
-	l.lastRefill.Store(now)
+	l.epoch = l.now()
+	l.epochNanos = l.epoch.UnixNano()
+
+	if l.store == nil {
+		l.local.tokens.Store(l.initialFill(l.capacity))
+		l.local.lastRefill.Store(l.nanotime())
+		l.store = &l.local
+	}
 
 	return l
 }
 
+// - is a constructor of atlimiter copies with capacity set directly instead of capacity factor.
+//
+// Takes maxRPS, the maximum number of requests per second, as a parameter.
+// Takes capacity, the max burst of requests, as a parameter. It's clamped up to maxRPS,
+// since capacity below the per-second rate is nonsensical.
+// Takes opts, optional settings of limiter, as a variadic parameter.
+func NewLimiterCap(maxRPS, capacity uint64, opts ...Option) *ATLimiter {
+	capacity = max(capacity, maxRPS, 1)
+
+	capacityFactor := 1.0
+	if maxRPS > 0 {
+		capacityFactor = float64(capacity) / float64(maxRPS)
+	}
+
+	return NewLimiter(maxRPS, capacityFactor, slices.Concat(opts, []Option{withCapacity(capacity)})...)
+}
+
+// - is a private option that sets capacity directly, used by NewLimiterCap.
+func withCapacity(capacity uint64) Option {
+	return func(r *ATLimiter) {
+		r.capacity = capacity
+	}
+}
+
+// - is a mode of rounding fractional product of maxRPS and capacityFactor to capacity.
+type CapacityRounding int
+
+const (
+	// CapacityTruncate rounds capacity down (e.g. maxRPS=3, factor=1.5 give capacity 4), it's the default.
+	CapacityTruncate CapacityRounding = iota
+	// CapacityRound rounds capacity to the nearest integer, halves away from zero (4.5 gives 5).
+	CapacityRound
+	// CapacityCeil rounds capacity up (4.1 gives 5).
+	CapacityCeil
+)
+
+// - is a policy of deciding on a request when tokens run out.
+type OverflowPolicy int
+
+const (
+	// OverflowStrict denies requests once tokens are exhausted, it's the default.
+	OverflowStrict OverflowPolicy = iota
+	// OverflowGrace permits one extra single-token request at zero tokens as debt.
+	// Next refill repays the debt before tokens become available, and no other grace is given until it's repaid.
+	OverflowGrace
+)
+
+// - is a private function that calculates capacity from maxRPS and capacityFactor.
+//
+// Factor below 1.0 (and NaN) is treated as 1.0, so capacity is never less than maxRPS or one.
+// Fractional product is rounded according to rounding mode.
+// Product that doesn't fit into uint64 is clamped to math.MaxUint64.
+func calculateCapacity(maxRPS uint64, capacityFactor float64, rounding CapacityRounding) uint64 {
+	if !(capacityFactor >= 1.0) {
+		capacityFactor = 1.0
+	}
+
+	product := float64(maxRPS) * capacityFactor
+	switch rounding {
+	case CapacityRound:
+		product = math.Round(product)
+	case CapacityCeil:
+		product = math.Ceil(product)
+	}
+
+	return max(max(floatToUint64(product), 1), maxRPS)
+}
+
+// - is a private function that converts float to uint64 saturating at math.MaxUint64.
+//
+// Negative numbers and NaN are converted to zero, since Go leaves their conversion implementation-specific.
+func floatToUint64(f float64) uint64 {
+	if !(f > 0) {
+		return 0
+	}
+	if f >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
+
+// - is a private function that converts nanoseconds in float to time.Duration saturating at max duration.
+func floatToDuration(nanoseconds float64) time.Duration {
+	if !(nanoseconds > 0) {
+		return 0
+	}
+	if nanoseconds >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(nanoseconds)
+}
+
+// Process-wide function deciding initial fill of new limiters, nil means full container.
+var defaultInitialTokens atomic.Pointer[func(capacity uint64) uint64]
+
+// - sets process-wide function that decides how many tokens a new limiter starts with.
+//
+// By default limiters start with full container (fn returns capacity), which allows immediate burst.
+// Result of fn is clamped to capacity. Passing nil restores default.
+// Per-limiter WithInitialTokens overrides this default.
+// It's safe to call concurrently, but it should be set at init time before limiters are created.
+func SetDefaultInitialTokens(fn func(capacity uint64) uint64) {
+	if fn == nil {
+		defaultInitialTokens.Store(nil)
+		return
+	}
+	defaultInitialTokens.Store(&fn)
+}
+
+// - is a private method of ATLimiter that returns quantity of tokens new container is filled with.
+func (r *ATLimiter) initialFill(capacity uint64) uint64 {
+	if r.hasInitialTokens {
+		return min(r.initialTokens, capacity)
+	}
+	if fn := defaultInitialTokens.Load(); fn != nil {
+		return min((*fn)(capacity), capacity)
+	}
+	return capacity
+}
+
 // - is a private method of ATLimiter that is responsible for calculating and generating new tokens.
 //
 // Quantity of new tokens calculates using elapsed time and maxRPS.
 // For comparing of previous refill of tokens and current time function uses compare-and-swap operation (that realised in sync/atomic/asm.s)
-// and realised on Go's assembler
+// and realised on Go's assembler.
+// Last refill is advanced only by the time that generated whole tokens, so fractional remainder is carried
+// to the next refill. Thereby repeated calls (e.g. frequent Available scrapes) don't lose elapsed time.
+// With WithMaxRefillStep tokens added by one refill are capped and the rest of elapsed time is carried forward too.
 func (r *ATLimiter) calculateTokenRefill() {
-	now := time.Now().UnixNano()
-	previousRefill := r.lastRefill.Load()
-
-	elapsed := float64(now-previousRefill) / 1e9
-
-	if elapsed > 0 {
-		if r.lastRefill.CompareAndSwap(previousRefill, now) {
-			newTokens := uint64(float64(r.maxRPS) * elapsed)
-			if newTokens > 0 {
-				current := r.tokens.Load()
-				newTotal := min(current+newTokens, r.capacity)
-				r.tokens.Store(newTotal)
-			}
-		}
+	r.refillAt(r.nanotime(), atomic.LoadUint64(&r.maxRPS))
+}
+
+// - is a private method of ATLimiter that generates new tokens for time elapsed until now in unix nanoseconds.
+//
+// maxRPS is loaded once by the caller, so the whole calculation uses one value even if SetMaxRPS runs concurrently.
+// If now isn't later than last refill nothing is generated.
+// Float math with carry of fractional time is the default: it doesn't lose tokens on frequent calls
+// and needs one CAS of last refill, see refill strategy benchmarks in refill_bench_test.go.
+func (r *ATLimiter) refillAt(now int64, maxRPS uint64) {
+	previousRefill := r.store.LoadRefill()
+
+	elapsed := now - previousRefill
+	if elapsed <= 0 {
+		return
+	}
+
+	if maxRPS == 0 {
+		r.store.CASRefill(previousRefill, now)
+		return
+	}
+
+	if r.windowReset > 0 {
+		r.resetWindow(previousRefill, now)
+		return
+	}
+
+	newTokens := floatToUint64(float64(maxRPS) * float64(elapsed) / 1e9)
+	if newTokens == 0 {
+		return
+	}
+	if r.maxRefillStep > 0 {
+		newTokens = min(newTokens, r.maxRefillStep)
+	}
+
+	spent := min(int64(float64(newTokens)*1e9/float64(maxRPS)), elapsed)
+	if !r.store.CASRefill(previousRefill, previousRefill+spent) {
+		r.refillsSkipped.Add(1)
+		return
+	}
+
+	r.refillsPerformed.Add(1)
+	if added := r.addTokens(newTokens); added < newTokens && spent < elapsed {
+		// Container is full, so time that wasn't turned into tokens can't be carried forward.
+		r.store.CASRefill(previousRefill+spent, now)
 	}
 }
 
-// - checks the request for available tokens and allows it if tokens are present.
+// - is a private method of ATLimiter that converts t to limiter's clock in unix nanoseconds.
+func (r *ATLimiter) toNanotime(t time.Time) int64 {
+	if r.wallClock {
+		return t.UnixNano()
+	}
+	return r.epochNanos + int64(t.Sub(r.epoch))
+}
+
+// - is a private method of ATLimiter that returns current time of limiter's clock.
+func (r *ATLimiter) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	if r.wallSource != nil {
+		return r.wallSource()
+	}
+	return time.Now()
+}
+
+// - is a private method of ATLimiter that returns channel receiving time of limiter's clock after d.
+func (r *ATLimiter) after(d time.Duration) <-chan time.Time {
+	if r.timer != nil {
+		return r.timer(d)
+	}
+	return time.After(d)
+}
+
+// - is a private method of ATLimiter that returns current time of limiter's clock in unix nanoseconds.
 //
-// If current quantity of tokens equals zero returns false.
-// If tokens available it's compare and swap current quantity and quantity minus one.
-func (r *ATLimiter) Allow() bool {
-	if r.maxRPS == 0 {
-		return true
+// In wall-clock mode it's wall time, so it jumps together with system clock.
+// In monotonic mode (default) it's creation wall time plus monotonic time elapsed since creation,
+// so it looks like unix nanoseconds but ignores system clock jumps.
+func (r *ATLimiter) nanotime() int64 {
+	if r.wallClock {
+		return r.now().UnixNano()
+	}
+	if r.clock != nil {
+		return r.epochNanos + int64(r.clock().Sub(r.epoch))
 	}
+	return r.epochNanos + int64(time.Since(r.epoch))
+}
 
-	r.calculateTokenRefill()
+// - is a private method of ATLimiter that fills container to capacity if now belongs to a later window than previous refill.
+//
+// Windows are aligned to multiples of windowReset since the Unix epoch, so with a minute interval
+// tokens reset at the top of each minute.
+func (r *ATLimiter) resetWindow(previousRefill, now int64) {
+	windowStart := now - now%int64(r.windowReset)
+	if previousRefill >= windowStart {
+		return
+	}
+	if !r.store.CASRefill(previousRefill, now) {
+		r.refillsSkipped.Add(1)
+		return
+	}
+
+	r.refillsPerformed.Add(1)
+	r.addTokens(atomic.LoadUint64(&r.capacity))
+}
+
+// - is a private method of ATLimiter that adds up to N = tokensCount of tokens clamped to capacity.
+//
+// Tokens repay debt of OverflowGrace first. Returns quantity of tokens actually added, including repaid debt.
+func (r *ATLimiter) addTokens(tokensCount uint64) uint64 {
+	var repaid uint64
+	if tokensCount > 0 && r.debt.Load() > 0 && r.debt.CompareAndSwap(1, 0) {
+		repaid = 1
+		tokensCount--
+	}
 
 	for {
-		current := r.tokens.Load()
-		if current == 0 {
-			return false
+		current := r.store.LoadTokens()
+		capacity := atomic.LoadUint64(&r.capacity)
+		if current >= capacity || tokensCount == 0 {
+			return repaid
 		}
-		if r.tokens.CompareAndSwap(current, current-1) {
-			return true
+		added := min(tokensCount, capacity-current)
+		if r.store.CASTokens(current, current+added) {
+			r.notifyEdge(current, current+added)
+			return repaid + added
 		}
 	}
 }
 
+// - is a private method of ATLimiter that calls edge hooks if quantity of tokens changed from before to after
+// crossing zero.
+//
+// It's called after successful compare and swap of tokens, so every transition is seen exactly once.
+func (r *ATLimiter) notifyEdge(before, after uint64) {
+	if r.onEmpty != nil && before > 0 && after == 0 {
+		r.callHook("empty", r.onEmpty)
+	}
+	if r.onRecovered != nil && before == 0 && after > 0 {
+		r.callHook("recovered", r.onRecovered)
+	}
+}
+
+// - is a private method of ATLimiter that removes up to N = tokensCount of tokens.
+//
+// Returns quantity of tokens actually removed.
+func (r *ATLimiter) removeTokens(tokensCount uint64) uint64 {
+	for {
+		current := r.store.LoadTokens()
+		removed := min(tokensCount, current)
+		if removed == 0 {
+			return 0
+		}
+		if r.store.CASTokens(current, current-removed) {
+			r.notifyEdge(current, current-removed)
+			return removed
+		}
+	}
+}
+
+// - checks the request for available tokens and allows it if tokens are present.
+//
+// If current quantity of tokens equals zero returns false.
+// If tokens available it's compare and swap current quantity and quantity minus one.
+func (r *ATLimiter) Allow() bool {
+	now := r.nanotime()
+	return r.record(now, r.take(now, 1), 1)
+}
+
 // - checks and allows N = tokensCount of requests.
 func (r *ATLimiter) TryAllow(tokensCount uint64) bool {
-	if r.maxRPS == 0 {
+	now := r.nanotime()
+	return r.record(now, r.take(now, tokensCount), tokensCount)
+}
+
+// - refills tokens against now and allows the request of N = cost tokens.
+//
+// It's a deterministic variant of TryAllow for replays and tests: time is taken from now instead of limiter's clock.
+// Refill happens only if now is later than last refill, so calls with past time just consume present tokens.
+func (r *ATLimiter) AllowAtCost(now time.Time, cost uint64) bool {
+	nanotime := r.toNanotime(now)
+	return r.record(nanotime, r.take(nanotime, cost), cost)
+}
+
+// - allows the request worth d of limiter's budget.
+//
+// Duration is converted to cost = ceil(maxRPS * d.Seconds()) tokens, so at 100 RPS 50ms costs 5 tokens.
+// Non-positive duration costs nothing. Duration that costs more than capacity is rejected immediately.
+func (r *ATLimiter) AllowDuration(d time.Duration) bool {
+	cost := r.durationCost(d)
+	now := r.nanotime()
+	return r.record(now, r.take(now, cost), cost)
+}
+
+// - is a private method of ATLimiter that converts d to quantity of tokens generated during it, rounded up.
+//
+// Calculation is made in integers to avoid float error like 100 * 0.05 = 5.000000000000001,
+// the result saturates at math.MaxUint64.
+func (r *ATLimiter) durationCost(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	hi, lo := bits.Mul64(atomic.LoadUint64(&r.maxRPS), uint64(d))
+	if hi >= uint64(time.Second) {
+		return math.MaxUint64
+	}
+	cost, rem := bits.Div64(hi, lo, uint64(time.Second))
+	if rem > 0 && cost < math.MaxUint64 {
+		cost++
+	}
+	return cost
+}
+
+// - allows the request taking a token only from those already present, without time-based refill.
+//
+// It's designed for setups where refill is driven externally, e.g. by TopUp from a ticker or by a shared store.
+// Unlimited limiter (maxRPS equals zero) always allows. Clock is read only for statistics
+// of denials and grant tracking, so allowed requests of a plain limiter don't read it at all.
+func (r *ATLimiter) Consume() bool {
+	allowed := r.takeAt(0, 1, r.softFloor(), false)
+
+	var now int64
+	if !allowed || r.trackGrants.Load() {
+		now = r.nanotime()
+	}
+	return r.record(now, allowed, 1)
+}
+
+// - adds up to N = tokensCount of tokens clamped to capacity and returns quantity actually added.
+//
+// It's the manual counterpart of Consume, e.g. for refill driven by an external ticker.
+func (r *ATLimiter) TopUp(tokensCount uint64) uint64 {
+	return r.addTokens(tokensCount)
+}
+
+// - is a private method of ATLimiter that reports if gate allows requests, gate that panicked is closed.
+func (r *ATLimiter) gateOpen() bool {
+	open := false
+	r.callHook("gate", func() { open = r.gate() })
+	return open
+}
+
+// - is a private method of ATLimiter that takes N = tokensCount of tokens at now if they are present.
+//
+// Unlike Allow and TryAllow it doesn't count decision in statistics, so blocking methods
+// can retry it without counting every unsuccessful attempt as denial.
+func (r *ATLimiter) take(now int64, tokensCount uint64) bool {
+	return r.takeAt(now, tokensCount, r.softFloor(), true)
+}
+
+// - is a private method of ATLimiter that refills tokens against now in unix nanoseconds
+// and takes N = tokensCount of tokens leaving at least floor of them in container.
+//
+// It's the single core of all allowing methods. maxRPS and capacity are loaded once,
+// so one call sees consistent values even if SetMaxRPS runs concurrently.
+// Without refill tokens are taken only from those already present.
+// Closed gate set by WithGate denies before tokens are checked, so no consuming path can bypass it.
+func (r *ATLimiter) takeAt(now int64, tokensCount, floor uint64, refill bool) bool {
+	if r.gate != nil && !r.gateOpen() {
+		return false
+	}
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if maxRPS == 0 {
 		return true
 	}
 	if tokensCount == 0 {
 		return true
 	}
-	if tokensCount > r.capacity {
+	if capacity := atomic.LoadUint64(&r.capacity); tokensCount > capacity-min(floor, capacity) {
 		return false
 	}
 
-	r.calculateTokenRefill()
+	if refill {
+		r.refillAt(now, maxRPS)
+	}
 
 	for {
-		current := r.tokens.Load()
-		if current < tokensCount {
-			return false
+		current := r.store.LoadTokens()
+		if current < tokensCount+floor {
+			return r.overflowPolicy == OverflowGrace && current == 0 && tokensCount == 1 && r.debt.CompareAndSwap(0, 1)
 		}
-		if r.tokens.CompareAndSwap(current, current-tokensCount) {
+		if r.store.CASTokens(current, current-tokensCount) {
+			r.notifyEdge(current, current-tokensCount)
 			return true
 		}
 	}
 }
 
+// - is a private method of ATLimiter that returns quantity of top tokens reserved for AllowPriority.
+//
+// With WithSoftLimit(n) ordinary requests can't take tokens once available drops to capacity-n.
+func (r *ATLimiter) softFloor() uint64 {
+	softLimit := atomic.LoadUint64(&r.softLimit)
+	capacity := atomic.LoadUint64(&r.capacity)
+	if softLimit == 0 || softLimit >= capacity {
+		return 0
+	}
+	return capacity - softLimit
+}
+
+// - is a private method of ATLimiter that calls soft rate hook at most once per second of now.
+func (r *ATLimiter) notifySoftExceed(now int64) {
+	windowStart := now - now%1e9
+	notified := r.softNotified.Load()
+	if notified >= windowStart || !r.softNotified.CompareAndSwap(notified, windowStart) {
+		return
+	}
+	r.callHook("soft rate", r.onSoftExceed)
+}
+
+// - allows the request ignoring soft limit set by WithSoftLimit.
+//
+// It can take tokens from reserved top slice of capacity, so it's designed for emergency traffic.
+func (r *ATLimiter) AllowPriority() bool {
+	now := r.nanotime()
+	return r.record(now, r.takeAt(now, 1, 0, true), 1)
+}
+
+// - is a private method of ATLimiter that counts decision about N = tokensCount of tokens made at now
+// in statistics and returns it unchanged.
+func (r *ATLimiter) record(now int64, allowed bool, tokensCount uint64) bool {
+	if allowed {
+		r.allowed.Add(1)
+		if r.logger != nil {
+			r.grantedTokens.Add(tokensCount)
+		}
+		if r.trackGrants.Load() {
+			granted := r.grants.add(now, tokensCount)
+			if r.onSoftExceed != nil && granted > r.softRPS {
+				r.notifySoftExceed(now)
+			}
+		}
+	} else {
+		r.denied.Add(1)
+		r.lastDenial.Store(now)
+		if r.denials != nil {
+			r.denials.add(DenialSample{At: time.Unix(0, now), Cost: tokensCount})
+		}
+	}
+	return allowed
+}
+
 // - returns quantity of available tokens
 func (r *ATLimiter) Available() uint64 {
 
 	r.calculateTokenRefill()
-	return r.tokens.Load()
+	return r.store.LoadTokens()
+}
+
+// - atomically replaces quantity of tokens with result of fn and returns new quantity.
+//
+// fn takes current quantity of tokens and capacity, its result is clamped to capacity.
+// fn is called in compare-and-swap loop and may run several times under contention,
+// so it must be pure and safe to retry.
+func (r *ATLimiter) Update(fn func(current, capacity uint64) uint64) uint64 {
+	r.calculateTokenRefill()
+
+	for {
+		current := r.store.LoadTokens()
+		capacity := atomic.LoadUint64(&r.capacity)
+		next := min(fn(current, capacity), capacity)
+		if r.store.CASTokens(current, next) {
+			r.notifyEdge(current, next)
+			return next
+		}
+	}
+}
+
+// - allows the request only if it keeps tokens granted during current second at or below f*maxRPS.
+//
+// It's voluntary under-utilization: cooperating clients leave headroom for others
+// (e.g. f=0.8 uses at most 80% of the allowed rate). Check and grant aren't atomic together,
+// so under concurrency the cap may be slightly exceeded.
+// f not less than 1.0 behaves like Allow, f not greater than zero (and NaN) always denies.
+// Unlimited limiter (maxRPS equals zero) behaves like Allow.
+// Without WithGrantTracking grants are counted from the first call.
+func (r *ATLimiter) AllowWithinFraction(f float64) bool {
+	r.enableGrantTracking()
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if f >= 1.0 || maxRPS == 0 {
+		return r.Allow()
+	}
+
+	now := r.nanotime()
+	if !(f > 0) || r.grants.current(now)+1 > floatToUint64(f*float64(maxRPS)) {
+		return r.record(now, false, 1)
+	}
+
+	return r.record(now, r.take(now, 1), 1)
+}
+
+// - returns how many tokens above the sustained-second rate are available right now.
+//
+// It's max(0, available - maxRPS), i.e. the size of burst limiter can absorb at the moment.
+func (r *ATLimiter) BurstHeadroom() uint64 {
+	available := r.Available()
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if available <= maxRPS {
+		return 0
+	}
+	return available - maxRPS
+}
+
+// - returns quantity of tokens granted during the last complete second of limiter's clock.
+//
+// Without WithGrantTracking grants are counted from the first call, so it returns zero until a second passes.
+func (r *ATLimiter) ObservedRate() uint64 {
+	r.enableGrantTracking()
+	return r.grants.last(r.nanotime())
+}
+
+// - is a private method of ATLimiter that starts counting grants per second, which is off by default
+// to keep allowing cheap for limiters that don't use it.
+func (r *ATLimiter) enableGrantTracking() {
+	if !r.trackGrants.Load() {
+		r.trackGrants.Store(true)
+	}
+}
+
+// - reports if additional steady traffic of rps requests per second fits into headroom left by observed load.
+//
+// Headroom is maxRPS minus ObservedRate, so it's designed for admission of new streams onto a shared limiter.
+// Unlimited limiter (maxRPS equals zero) always returns true.
+func (r *ATLimiter) CanSustain(rps uint64) bool {
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if maxRPS == 0 {
+		return true
+	}
+	observed := r.ObservedRate()
+	return observed <= maxRPS && rps <= maxRPS-observed
+}
+
+// - returns max quantity of requests the limiter could grant over the next d duration.
+//
+// It's available tokens plus tokens generated during d minus debt of OverflowGrace. It isn't bounded by capacity,
+// because tokens are spent as they arrive. Unlimited limiter (maxRPS equals zero) returns math.MaxUint64.
+func (r *ATLimiter) MaxGrantsOver(d time.Duration) uint64 {
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if maxRPS == 0 {
+		return math.MaxUint64
+	}
+
+	available := r.Available()
+	if d <= 0 {
+		return available
+	}
+
+	generated := math.Floor(float64(maxRPS) * d.Seconds())
+	if generated >= math.MaxUint64 || uint64(generated) > math.MaxUint64-available {
+		return math.MaxUint64
+	}
+	// Debt of OverflowGrace is repaid from generated tokens.
+	return available + uint64(generated) - min(r.debt.Load(), uint64(generated))
+}
+
+// - returns how long the caller has to wait until N = tokensCount of tokens become available.
+//
+// Returns zero if tokens are available right now or limiter is unlimited (maxRPS equals zero).
+// Tokens reserved by WithSoftLimit aren't considered available, debt of OverflowGrace has to be repaid first.
+// If tokensCount exceeds capacity tokens will never be available and maximum duration is returned.
+func (r *ATLimiter) TimeUntilAvailable(tokensCount uint64) time.Duration {
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if maxRPS == 0 || tokensCount == 0 {
+		return 0
+	}
+
+	floor := r.softFloor()
+	if tokensCount > atomic.LoadUint64(&r.capacity)-floor {
+		return time.Duration(math.MaxInt64)
+	}
+
+	required := tokensCount + floor
+	current := r.Available()
+	if current >= required {
+		return 0
+	}
+
+	if r.windowReset > 0 {
+		now := r.nanotime()
+		return time.Duration(int64(r.windowReset) - now%int64(r.windowReset))
+	}
+
+	// Debt of OverflowGrace is repaid before tokens become available.
+	missing := required - current + r.debt.Load()
+	progress := max(r.nanotime()-r.store.LoadRefill(), 0)
+	return floatToDuration(math.Ceil(float64(missing)*1e9/float64(maxRPS)) - float64(progress))
+}
+
+// - blocks until one token is available or ctx is done.
+//
+// It returns ErrNotStarted if WithDynamicRate is configured but Start wasn't called, like WaitN and WaitNTimed.
+func (r *ATLimiter) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+// - blocks until N = tokensCount of tokens are available or ctx is done.
+//
+// Sleep duration is estimated by TimeUntilAvailable, after wake up tokens are taken with TryAllow.
+// If other goroutines took tokens first it estimates and sleeps again.
+// With WithFairWaiting waiters are served in FIFO order.
+func (r *ATLimiter) WaitN(ctx context.Context, tokensCount uint64) error {
+	_, err := r.WaitNTimed(ctx, tokensCount)
+	return err
+}
+
+// - works like WaitN and also returns how long it blocked.
+//
+// Duration is measured by limiter's clock from the call to return, it's zero if tokens were taken immediately.
+// It's designed for callers that log or histogram waits themselves.
+func (r *ATLimiter) WaitNTimed(ctx context.Context, tokensCount uint64) (time.Duration, error) {
+	if err := r.checkDynamicRate(); err != nil {
+		return 0, err
+	}
+	if atomic.LoadUint64(&r.maxRPS) != 0 && tokensCount > atomic.LoadUint64(&r.capacity)-r.softFloor() {
+		return 0, ErrExceedsCapacity
+	}
+
+	start := r.nanotime()
+	var blocked bool
+	var err error
+	if r.fairWaiting {
+		blocked, err = r.waitFair(ctx, tokensCount)
+	} else {
+		blocked, err = r.wait(ctx, tokensCount)
+	}
+
+	if !blocked {
+		return 0, err
+	}
+	return time.Duration(max(r.nanotime()-start, 0)), err
+}
+
+// - is a private method of ATLimiter that sleeps and retries until tokens are taken or ctx is done.
+//
+// Sleep is interrupted by SetMaxRPS, so waiters re-evaluate their wait under new rate
+// and return immediately when limiter becomes unlimited.
+// Reports if tokens weren't taken on the first attempt, so the caller blocked.
+func (r *ATLimiter) wait(ctx context.Context, tokensCount uint64) (blocked bool, err error) {
+	for ; ; blocked = true {
+		if err := ctx.Err(); err != nil {
+			return blocked, err
+		}
+
+		reconfigured := *r.reconfigured.Load()
+		now := r.nanotime()
+		if r.take(now, tokensCount) {
+			r.record(now, true, tokensCount)
+			addConsumed(ctx, tokensCount)
+			return blocked, nil
+		}
+		if tokensCount > atomic.LoadUint64(&r.capacity)-r.softFloor() {
+			return blocked, ErrExceedsCapacity
+		}
+		if r.gate != nil && !r.gateOpen() {
+			return blocked, ErrGateClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-reconfigured:
+		case <-r.after(r.TimeUntilAvailable(tokensCount)):
+		}
+	}
+}
+
+// - allows the request considering ctx, never blocking.
+//
+// If ctx is already done the request is denied without taking a token, because its caller gave up.
+// If ctx has a deadline and a token won't free up before it, measured by limiter's clock, the request is denied
+// without taking a token too, so handlers with tight deadlines fail fast, e.g. instead of running into OverflowGrace debt.
+// Otherwise a token is taken if it's available right now, else the request is denied immediately.
+// Callers that can afford to wait until the deadline use Wait.
+// Consumed token is added to ctx's accumulator set by WithAccounting.
+func (r *ATLimiter) AllowCtx(ctx context.Context) bool {
+	now := r.nanotime()
+	if ctx.Err() != nil {
+		return r.record(now, false, 1)
+	}
+	if deadline, ok := ctx.Deadline(); ok && r.TimeUntilAvailable(1) > deadline.Sub(r.now()) {
+		return r.record(now, false, 1)
+	}
+	if !r.take(now, 1) {
+		return r.record(now, false, 1)
+	}
+
+	r.record(now, true, 1)
+	addConsumed(ctx, 1)
+	return true
+}
+
+// - runs do if a token becomes available within budget and fallback otherwise.
+//
+// Budget is checked with TimeUntilAvailable before waiting, so under pressure fallback is called immediately.
+// If waiting took longer than budget because of contention fallback is called too.
+// If ctx is done before token is taken ctx's error is returned and neither function is called.
+// ErrNotStarted is returned the same way if WithDynamicRate is configured but Start wasn't called.
+func (r *ATLimiter) DoOrFallback(ctx context.Context, budget time.Duration, do, fallback func() error) error {
+	if err := r.checkDynamicRate(); err != nil {
+		return err
+	}
+	if now := r.nanotime(); r.take(now, 1) {
+		r.record(now, true, 1)
+		addConsumed(ctx, 1)
+		return do()
+	}
+	if r.TimeUntilAvailable(1) > budget {
+		return fallback()
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	if err := r.Wait(waitCtx); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fallback()
+	}
+
+	return do()
+}
+
+// - is a point-in-time copy of limiter's token state, e.g. to persist it across restarts.
+type Snapshot struct {
+	// Quantity of tokens in container
+	Tokens uint64
+	// Time of last refill
+	LastRefill time.Time
+}
+
+// - returns current token state of limiter.
+//
+// Snapshot is meaningful across processes only in wall-clock mode (WithWallClock),
+// in monotonic mode last refill is measured from creation of this limiter.
+func (r *ATLimiter) Snapshot() Snapshot {
+	r.calculateTokenRefill()
+
+	return Snapshot{
+		Tokens:     r.store.LoadTokens(),
+		LastRefill: time.Unix(0, r.store.LoadRefill()),
+	}
+}
+
+// - replaces token state of limiter with snapshot.
+//
+// Tokens are clamped to capacity. In wall-clock mode the limiter refills for the real time
+// elapsed since snapshot's last refill, in monotonic mode restored time isn't reliable.
+func (r *ATLimiter) Restore(s Snapshot) {
+	lastRefill := s.LastRefill.UnixNano()
+	for {
+		previous := r.store.LoadRefill()
+		if r.store.CASRefill(previous, lastRefill) {
+			break
+		}
+	}
+
+	tokens := min(s.Tokens, atomic.LoadUint64(&r.capacity))
+	for {
+		current := r.store.LoadTokens()
+		if r.store.CASTokens(current, tokens) {
+			r.notifyEdge(current, tokens)
+			break
+		}
+	}
+}
+
+// - returns current values of limiter's counters.
+//
+// Requests that waited in Wait and WaitN are counted as allowed once they take tokens.
+func (r *ATLimiter) Stats() Stats {
+	return Stats{
+		Allowed:          r.allowed.Load(),
+		Denied:           r.denied.Load(),
+		RefillsPerformed: r.refillsPerformed.Load(),
+		RefillsSkipped:   r.refillsSkipped.Load(),
+		Tokens:           r.Available(),
+		Capacity:         atomic.LoadUint64(&r.capacity),
+		HookPanics:       r.hookPanics.Load(),
+		LastDenialAt:     r.lastDenialAt(),
+		QueueLen:         uint64(max(r.queued.Load(), 0)),
+	}
+}
+
+// - is a private method of ATLimiter that returns time of last denial or zero time if there were no denials.
+func (r *ATLimiter) lastDenialAt() time.Time {
+	nanos := r.lastDenial.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// - returns quantity of denied requests and resets it to zero in one atomic swap.
+//
+// It's designed for delta-based reporting, so each scrape reports denials since the previous one.
+// Other counters stay untouched.
+func (r *ATLimiter) SwapDenied() uint64 {
+	return r.denied.Swap(0)
+}
+
+// - returns quantity of allowed requests and resets it to zero in one atomic swap.
+func (r *ATLimiter) SwapAllowed() uint64 {
+	return r.allowed.Swap(0)
+}
+
+// - returns aggregate statistics of several limiters, e.g. of clones serving one logical limit.
+//
+// Counters, tokens and capacity are summed, each counter is read atomically.
+// LastDenialAt is the latest of limiters' last denials.
+// Sums saturate at math.MaxUint64 instead of overflowing.
+func MergeStats(limiters ...*ATLimiter) Stats {
+	var merged Stats
+	for _, limiter := range limiters {
+		stats := limiter.Stats()
+		merged.Allowed = saturatingAdd(merged.Allowed, stats.Allowed)
+		merged.Denied = saturatingAdd(merged.Denied, stats.Denied)
+		merged.RefillsPerformed = saturatingAdd(merged.RefillsPerformed, stats.RefillsPerformed)
+		merged.RefillsSkipped = saturatingAdd(merged.RefillsSkipped, stats.RefillsSkipped)
+		merged.Tokens = saturatingAdd(merged.Tokens, stats.Tokens)
+		merged.Capacity = saturatingAdd(merged.Capacity, stats.Capacity)
+		merged.HookPanics = saturatingAdd(merged.HookPanics, stats.HookPanics)
+		merged.QueueLen = saturatingAdd(merged.QueueLen, stats.QueueLen)
+		if stats.LastDenialAt.After(merged.LastDenialAt) {
+			merged.LastDenialAt = stats.LastDenialAt
+		}
+	}
+	return merged
+}
+
+// - is a private function that adds two numbers saturating at math.MaxUint64.
+func saturatingAdd(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
 }
 
 // - is a function designed to change maxRPS and capacity during execution.
 //
 // Takes newMaxRPS, the new maximum number of requests per second, as a parameter.
 // Takes capacityFactor, new capacity increase multiplier in float64 number, as a parameter.
-// If current quantity of tokens is more than new calculated capacity it's compare and swap it with new.
+// If current quantity of tokens is more than new calculated capacity it's compare and swap it with new until it succeeds.
+// Goroutines blocked in Wait are woken up to re-evaluate their wait under new rate.
+// In smoothing mode capacity stays equal to one and newCapacityFactor is ignored.
 func (r *ATLimiter) SetMaxRPS(newMaxRPS uint64, newCapacityFactor float64) {
-	if newCapacityFactor < 1.0 {
-		newCapacityFactor = 1.0
+	newCapacity := calculateCapacity(newMaxRPS, newCapacityFactor, r.rounding)
+	if r.smoothing {
+		newCapacity = 1
 	}
 
-	newCapacity := max(max(uint64(float64(newMaxRPS)*newCapacityFactor), 1), newMaxRPS)
-
 	atomic.StoreUint64(&r.maxRPS, newMaxRPS)
 	atomic.StoreUint64(&r.capacity, newCapacity)
+	atomic.StoreUint64(&r.factorBits, math.Float64bits(newCapacityFactor))
+	r.clampTokens(newCapacity)
 
-	current := r.tokens.Load()
-	if current > newCapacity {
-		r.tokens.CompareAndSwap(current, newCapacity)
+	r.notifyReconfigured()
+}
+
+// - is a private method of ATLimiter that changes capacity keeping maxRPS, e.g. by capacity auto-tuning.
+//
+// Capacity factor is updated to match new capacity, goroutines blocked in Wait are woken up.
+func (r *ATLimiter) setCapacity(newCapacity uint64) {
+	atomic.StoreUint64(&r.capacity, newCapacity)
+	if maxRPS := atomic.LoadUint64(&r.maxRPS); maxRPS > 0 {
+		atomic.StoreUint64(&r.factorBits, math.Float64bits(float64(newCapacity)/float64(maxRPS)))
 	}
+	r.clampTokens(newCapacity)
+
+	r.notifyReconfigured()
+}
+
+// - is a private method of ATLimiter that compares and swaps quantity of tokens with capacity until it isn't above it.
+func (r *ATLimiter) clampTokens(capacity uint64) {
+	for {
+		current := r.store.LoadTokens()
+		if current <= capacity || r.store.CASTokens(current, capacity) {
+			break
+		}
+	}
+}
+
+// - is a private method of ATLimiter that wakes up goroutines blocked in Wait to re-evaluate their wait.
+func (r *ATLimiter) notifyReconfigured() {
+	next := make(chan struct{})
+	close(*r.reconfigured.Swap(&next))
 }
 
 // - returns current max RPS
@@ -149,7 +1122,12 @@ func (r *ATLimiter) GetMaxRPS() uint64 {
 	return atomic.LoadUint64(&r.maxRPS)
 }
 
+// - returns current capacity increase multiplier as it was passed to constructor or SetMaxRPS
+func (r *ATLimiter) GetCapacityFactor() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&r.factorBits))
+}
+
 // - returns current capacity
 func (r *ATLimiter) GetCapacity() uint64 {
-	return r.capacity
+	return atomic.LoadUint64(&r.capacity)
 }
@@ -3,13 +3,91 @@ package atlimiter
 // ok  	atlimiter	3.090s	coverage: 90.4% of statements
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// - is a manually advanced clock for deterministic tests.
+//
+// Timers created by After fire when Advance reaches their deadline.
+type fakeClock struct {
+	nanoseconds atomic.Int64
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	timers  []fakeTimer
+}
+
+// - is a timer of fakeClock waiting for its deadline.
+type fakeTimer struct {
+	deadline int64
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{}
+	c.changed = sync.NewCond(&c.mu)
+	c.nanoseconds.Store(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Unix(0, c.nanoseconds.Load())
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.nanoseconds.Add(int64(d))
+	c.timers = slices.DeleteFunc(c.timers, func(timer fakeTimer) bool {
+		if timer.deadline > now {
+			return false
+		}
+		timer.ch <- time.Unix(0, now)
+		return true
+	})
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	now := c.nanoseconds.Load()
+	if d <= 0 {
+		ch <- time.Unix(0, now)
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{deadline: now + int64(d), ch: ch})
+	c.changed.Broadcast()
+	return ch
+}
+
+// - blocks until n timers wait for their deadlines, i.e. goroutines using the clock are blocked.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// Sequence that makes names of process-wide registrations unique across repeated test runs.
+var nameSequence atomic.Uint64
+
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, nameSequence.Add(1))
+}
+
 func TestNewLimiter(t *testing.T) {
 	limiter := NewLimiter(100, 1.5)
 	if limiter == nil {
@@ -28,6 +106,119 @@ func TestNewLimiter(t *testing.T) {
 	}
 }
 
+func TestCapacityRounding(t *testing.T) {
+	cases := []struct {
+		mode     CapacityRounding
+		factor   float64
+		expected uint64
+	}{
+		{CapacityTruncate, 1.5, 4},
+		{CapacityRound, 1.5, 5},
+		{CapacityCeil, 1.5, 5},
+		{CapacityTruncate, 1.4, 4},
+		{CapacityRound, 1.4, 4},
+		{CapacityCeil, 1.4, 5},
+	}
+
+	for _, c := range cases {
+		limiter := NewLimiter(3, c.factor, WithCapacityRounding(c.mode))
+		if capacity := limiter.GetCapacity(); capacity != c.expected {
+			t.Errorf("Mode %d, factor %v: expected capacity %d, got %d", c.mode, c.factor, c.expected, capacity)
+		}
+	}
+
+	if capacity := NewLimiter(3, 1.5).GetCapacity(); capacity != 4 {
+		t.Errorf("Default mode should truncate, expected capacity 4, got %d", capacity)
+	}
+
+	limiter := NewLimiter(3, 1.0, WithCapacityRounding(CapacityCeil))
+	limiter.SetMaxRPS(5, 1.3)
+	if capacity := limiter.GetCapacity(); capacity != 7 {
+		t.Errorf("SetMaxRPS should use rounding mode, expected capacity 7, got %d", capacity)
+	}
+}
+
+func TestNewLimiterCap(t *testing.T) {
+	limiter := NewLimiterCap(100, 250)
+	if capacity := limiter.GetCapacity(); capacity != 250 {
+		t.Errorf("Expected capacity 250, got %d", capacity)
+	}
+	if available := limiter.Available(); available != 250 {
+		t.Errorf("Expected full container of 250 tokens, got %d", available)
+	}
+	if factor := limiter.GetCapacityFactor(); factor != 2.5 {
+		t.Errorf("Expected capacity factor 2.5, got %v", factor)
+	}
+
+	if capacity := NewLimiterCap(100, 30).GetCapacity(); capacity != 100 {
+		t.Errorf("Capacity should be clamped up to maxRPS 100, got %d", capacity)
+	}
+	if capacity := NewLimiterCap(0, 0).GetCapacity(); capacity != 1 {
+		t.Errorf("Capacity should never be zero, got %d", capacity)
+	}
+	if capacity := NewLimiterCap(3, 10).GetCapacity(); capacity != 10 {
+		t.Errorf("Expected exact capacity 10 without factor rounding, got %d", capacity)
+	}
+
+	opts := make([]Option, 1, 2)
+	opts[0] = WithSmoothing()
+	NewLimiterCap(100, 250, opts...)
+	if extra := opts[:2][1]; extra != nil {
+		t.Error("Caller's options should not be modified")
+	}
+}
+
+func TestNewLimiterEdgeCases(t *testing.T) {
+	if capacity := NewLimiter(10, math.NaN()).GetCapacity(); capacity != 10 {
+		t.Errorf("NaN factor should be treated as 1.0, expected capacity 10, got %d", capacity)
+	}
+	if capacity := NewLimiter(10, math.Inf(1)).GetCapacity(); capacity != math.MaxUint64 {
+		t.Errorf("Infinite factor should saturate capacity, got %d", capacity)
+	}
+	if capacity := NewLimiter(math.MaxUint64, 2.0).GetCapacity(); capacity != math.MaxUint64 {
+		t.Errorf("Overflowing product should saturate capacity, got %d", capacity)
+	}
+	if capacity := NewLimiter(0, 1.0).GetCapacity(); capacity != 1 {
+		t.Errorf("Capacity should never be zero, got %d", capacity)
+	}
+}
+
+func TestInitialTokens(t *testing.T) {
+	if available := NewLimiter(10, 2.0, WithInitialTokens(5)).Available(); available != 5 {
+		t.Errorf("Expected 5 initial tokens, got %d", available)
+	}
+	if available := NewLimiter(10, 2.0, WithInitialTokens(100)).Available(); available != 20 {
+		t.Errorf("Initial tokens should be clamped to capacity 20, got %d", available)
+	}
+}
+
+func TestSetDefaultInitialTokens(t *testing.T) {
+	SetDefaultInitialTokens(func(uint64) uint64 { return 0 })
+	defer SetDefaultInitialTokens(nil)
+
+	limiter := NewLimiter(10, 2.0)
+	if available := limiter.Available(); available != 0 {
+		t.Errorf("Expected empty limiter with zero default, got %d", available)
+	}
+	if limiter.Allow() {
+		t.Error("Request should be denied by empty limiter")
+	}
+
+	if available := NewLimiter(10, 2.0, WithInitialTokens(3)).Available(); available != 3 {
+		t.Errorf("WithInitialTokens should override default, expected 3, got %d", available)
+	}
+
+	SetDefaultInitialTokens(func(capacity uint64) uint64 { return capacity / 2 })
+	if available := NewLimiter(10, 2.0).Available(); available != 10 {
+		t.Errorf("Expected half-capacity default 10, got %d", available)
+	}
+
+	SetDefaultInitialTokens(nil)
+	if available := NewLimiter(10, 2.0).Available(); available != 20 {
+		t.Errorf("Expected full container after restoring default, got %d", available)
+	}
+}
+
 func TestAllow(t *testing.T) {
 	limiter := NewLimiter(10, 2.0)
 
@@ -70,6 +261,174 @@ func TestTryAllow(t *testing.T) {
 	}
 }
 
+func TestAllowAtCost(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	limiter := NewLimiter(10, 2.0, WithClock(clock.Now))
+
+	if !limiter.AllowAtCost(start, 15) {
+		t.Error("Should allow 15 tokens at start")
+	}
+	if limiter.AllowAtCost(start, 6) {
+		t.Error("Should deny 6 tokens with 5 left")
+	}
+	if !limiter.AllowAtCost(start.Add(100*time.Millisecond), 6) {
+		t.Error("Should allow 6 tokens after 100ms refill")
+	}
+	if limiter.AllowAtCost(start, 1) {
+		t.Error("Past time should not refill, bucket is empty")
+	}
+	if !limiter.AllowAtCost(start.Add(time.Second), 9) {
+		t.Error("Should allow 9 tokens refilled by 1 second")
+	}
+	if limiter.AllowAtCost(start.Add(time.Second), 1) {
+		t.Error("Should deny with empty bucket at the same time")
+	}
+	if limiter.AllowAtCost(start.Add(time.Hour), 21) {
+		t.Error("Should deny cost over capacity")
+	}
+
+	if stats := limiter.Stats(); stats.Allowed != 3 || stats.Denied != 4 {
+		t.Errorf("Expected 3 allowed and 4 denied, got %d and %d", stats.Allowed, stats.Denied)
+	}
+}
+
+func TestAllowDuration(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(100, 1.0, WithClock(clock.Now))
+
+	for d, cost := range map[time.Duration]uint64{
+		50 * time.Millisecond: 5,
+		55 * time.Millisecond: 6,
+		time.Nanosecond:       1,
+		0:                     0,
+		-time.Second:          0,
+	} {
+		if got := limiter.durationCost(d); got != cost {
+			t.Errorf("Expected %v to cost %d tokens, got %d", d, cost, got)
+		}
+	}
+
+	if !limiter.AllowDuration(50 * time.Millisecond) {
+		t.Error("Should allow 50ms worth of budget")
+	}
+	if tokens := limiter.Available(); tokens != 95 {
+		t.Errorf("Expected 95 tokens left, got %d", tokens)
+	}
+	if limiter.AllowDuration(2 * time.Second) {
+		t.Error("Should deny duration costing more than capacity")
+	}
+	if tokens := limiter.Available(); tokens != 95 {
+		t.Errorf("Denied duration should not consume tokens, got %d", tokens)
+	}
+	if cost := NewLimiter(math.MaxUint64, 1.0).durationCost(time.Hour); cost != math.MaxUint64 {
+		t.Errorf("Expected saturated cost, got %d", cost)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	clock := newFakeClock()
+	var reads atomic.Int64
+	limiter := NewLimiter(10, 1.0, WithInitialTokens(0), WithClock(func() time.Time {
+		reads.Add(1)
+		return clock.Now()
+	}))
+
+	clock.Advance(10 * time.Second)
+	if limiter.Consume() {
+		t.Error("Consume should not refill by elapsed time")
+	}
+	if limiter.lastDenial.Load() == 0 {
+		t.Error("Denial should be recorded with time of limiter's clock")
+	}
+
+	if added := limiter.TopUp(3); added != 3 {
+		t.Errorf("Expected 3 tokens added, got %d", added)
+	}
+	before := reads.Load()
+	for i := range 3 {
+		if !limiter.Consume() {
+			t.Errorf("Consume %d should take manually added token", i)
+		}
+	}
+	if read := reads.Load() - before; read != 0 {
+		t.Errorf("Allowed Consume should not read clock, got %d reads", read)
+	}
+	if limiter.Consume() {
+		t.Error("Consume should deny after manually added tokens are spent")
+	}
+
+	if added := limiter.TopUp(100); added != 10 {
+		t.Errorf("TopUp should be clamped to capacity, got %d added", added)
+	}
+	if !NewLimiter(0, 1.0).Consume() {
+		t.Error("Unlimited limiter should always allow")
+	}
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	clock := newFakeClock()
+	strict := NewLimiter(10, 1.0, WithClock(clock.Now))
+	grace := NewLimiter(10, 1.0, WithClock(clock.Now), WithOverflowPolicy(OverflowGrace))
+
+	if allowed := countAllowed(strict, 20); allowed != 10 {
+		t.Errorf("Strict policy should deny at zero, got %d allowed", allowed)
+	}
+	if allowed := countAllowed(grace, 20); allowed != 11 {
+		t.Errorf("Grace policy should permit one extra grant, got %d allowed", allowed)
+	}
+	if d := grace.TimeUntilAvailable(1); d != 200*time.Millisecond {
+		t.Errorf("Next token should take 200ms including debt, got %v", d)
+	}
+	if grants := grace.MaxGrantsOver(time.Second); grants != 9 {
+		t.Errorf("Expected 9 grants over a second after repaying debt, got %d", grants)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if tokens := grace.Available(); tokens != 0 {
+		t.Errorf("First refilled token should repay debt, got %d tokens", tokens)
+	}
+	clock.Advance(200 * time.Millisecond)
+	if tokens := grace.Available(); tokens != 2 {
+		t.Errorf("Expected 2 tokens after debt is repaid, got %d", tokens)
+	}
+
+	grace.TryAllow(2)
+	if grace.TryAllow(2) {
+		t.Error("Grace should not apply to multi-token requests")
+	}
+}
+
+func TestGate(t *testing.T) {
+	var open atomic.Bool
+	limiter := NewLimiter(10, 1.0, WithGate(open.Load))
+
+	if limiter.Allow() || limiter.TryAllow(5) {
+		t.Error("Closed gate should deny with full bucket")
+	}
+	if err := limiter.Wait(context.Background()); !errors.Is(err, ErrGateClosed) {
+		t.Errorf("Closed gate should refuse Wait, got %v", err)
+	}
+	if limiter.AllowCtx(context.Background()) {
+		t.Error("Closed gate should deny AllowCtx")
+	}
+	if tokens := limiter.Available(); tokens != 10 {
+		t.Errorf("Denial by gate should not consume tokens, got %d", tokens)
+	}
+
+	open.Store(true)
+	if !limiter.TryAllow(10) {
+		t.Error("Open gate should allow with tokens")
+	}
+	if limiter.Allow() {
+		t.Error("Open gate should not bypass empty bucket")
+	}
+
+	if stats := limiter.Stats(); stats.Allowed != 1 || stats.Denied != 4 {
+		t.Errorf("Expected 1 allowed and 4 denied, got %d and %d", stats.Allowed, stats.Denied)
+	}
+}
+
 func TestConcurrentRefill(t *testing.T) {
 	limiter := NewLimiter(1000, 2.0)
 	var wg sync.WaitGroup
@@ -119,6 +478,64 @@ func TestMultipleRefillAttempts(t *testing.T) {
 	t.Logf("Refill was attempted by %d goroutines", refillCount.Load())
 }
 
+func TestMaxRefillStep(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(100, 1.0, WithClock(clock.Now), WithMaxRefillStep(10))
+	limiter.TryAllow(100)
+
+	clock.Advance(500 * time.Millisecond)
+	for step := range 5 {
+		if available := limiter.Available(); available != uint64(step+1)*10 {
+			t.Errorf("Step %d: expected %d tokens recovered in capped steps, got %d", step, (step+1)*10, available)
+		}
+	}
+	if available := limiter.Available(); available != 50 {
+		t.Errorf("Carried time should be exhausted after 50 tokens, got %d", available)
+	}
+
+	clock.Advance(10 * time.Second)
+	for range 10 {
+		limiter.Available()
+	}
+	if available := limiter.Available(); available != 100 {
+		t.Fatalf("Expected full container, got %d", available)
+	}
+
+	limiter.TryAllow(100)
+	if available := limiter.Available(); available != 0 {
+		t.Errorf("Time carried over full container should be discarded, got %d tokens", available)
+	}
+}
+
+func TestRefillContention(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(10, 100.0, WithClock(clock.Now))
+
+	const steps = 20
+	for range steps {
+		clock.Advance(time.Second)
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				limiter.Allow()
+			}()
+		}
+		close(start)
+		wg.Wait()
+	}
+
+	stats := limiter.Stats()
+	if stats.RefillsPerformed != steps {
+		t.Errorf("Expected exactly one performed refill per clock step, got %d", stats.RefillsPerformed)
+	}
+	t.Logf("Refills performed: %d, skipped: %d", stats.RefillsPerformed, stats.RefillsSkipped)
+}
+
 func TestAvailable(t *testing.T) {
 	limiter := NewLimiter(10, 2.0)
 
@@ -138,6 +555,66 @@ func TestAvailable(t *testing.T) {
 	}
 }
 
+func TestRefillUnaffectedByScrapes(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(10, 1.0, WithClock(clock.Now))
+	limiter.TryAllow(10)
+
+	previous := uint64(0)
+	for i := range 1000 {
+		clock.Advance(time.Millisecond)
+		available := limiter.Available()
+		if available < previous {
+			t.Fatalf("Available decreased from %d to %d without requests", previous, available)
+		}
+		if expected := uint64(i+1) / 100; available != expected {
+			t.Fatalf("Expected %d tokens after %dms of scrapes, got %d", expected, i+1, available)
+		}
+		previous = available
+	}
+}
+
+func TestSnapshotRestoreWallClock(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(10, 1.0, WithWallClock(), WithClock(clock.Now))
+	limiter.TryAllow(8)
+
+	snapshot := limiter.Snapshot()
+	if snapshot.Tokens != 2 {
+		t.Errorf("Expected 2 tokens in snapshot, got %d", snapshot.Tokens)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+
+	restored := NewLimiter(10, 1.0, WithWallClock(), WithClock(clock.Now))
+	restored.Restore(snapshot)
+	if available := restored.Available(); available != 7 {
+		t.Errorf("Restored limiter should refill for elapsed real time, expected 7, got %d", available)
+	}
+}
+
+func TestMonotonicClockIgnoresWallJump(t *testing.T) {
+	// System clock jump is simulated by wall source of both limiters, monotonic source stays real.
+	var jump atomic.Int64
+	withJumpingWall := func(r *ATLimiter) {
+		r.wallSource = func() time.Time { return time.Now().Add(time.Duration(jump.Load())) }
+	}
+
+	wall := NewLimiter(10, 1.0, WithWallClock(), withJumpingWall)
+	monotonic := NewLimiter(10, 1.0, WithMonotonicClock(), withJumpingWall)
+	wall.TryAllow(10)
+	monotonic.TryAllow(10)
+
+	jump.Store(int64(time.Hour))
+
+	if available := wall.Available(); available != 10 {
+		t.Errorf("Wall-clock limiter should follow clock jump and refill, got %d", available)
+	}
+	if available := monotonic.Available(); available != 0 {
+		t.Errorf("Monotonic limiter should ignore wall-clock jump, got %d", available)
+	}
+}
+
 func TestSetMaxRPS(t *testing.T) {
 	limiter := NewLimiter(10, 2.0)
 
@@ -148,6 +625,48 @@ func TestSetMaxRPS(t *testing.T) {
 	if limiter.GetCapacity() != 30 {
 		t.Errorf("Expected capacity 30 after SetMaxRPS, got %d", limiter.GetCapacity())
 	}
+	if limiter.GetCapacityFactor() != 1.5 {
+		t.Errorf("Expected capacity factor 1.5 after SetMaxRPS, got %v", limiter.GetCapacityFactor())
+	}
+}
+
+func TestConcurrentSetMaxRPS(t *testing.T) {
+	limiter := NewLimiter(1000, 1.0)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// Alternate with unlimited mode to exercise the zero maxRPS short-circuit
+			limiter.SetMaxRPS(i%3*1000, 1.0+float64(i%2))
+		}
+	}()
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5000 {
+				limiter.Allow()
+				limiter.TryAllow(3)
+				if tokens := limiter.Available(); tokens > 4000 {
+					t.Errorf("Tokens %d above max capacity 4000", tokens)
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	wg.Wait()
 }
 
 func TestConcurrentAccess(t *testing.T) {
@@ -185,6 +704,525 @@ func TestZeroRPS(t *testing.T) {
 	}
 }
 
+func TestUpdate(t *testing.T) {
+	limiter := NewLimiter(1, 1024.0, WithClock(newFakeClock().Now))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Update(func(current, _ uint64) uint64 {
+				return current / 2
+			})
+		}()
+	}
+	wg.Wait()
+
+	if available := limiter.Available(); available != 1 {
+		t.Errorf("Expected 1024 halved 10 times to be 1, got %d", available)
+	}
+
+	if tokens := limiter.Update(func(_, capacity uint64) uint64 { return capacity * 2 }); tokens != 1024 {
+		t.Errorf("Update result should be clamped to capacity 1024, got %d", tokens)
+	}
+}
+
+func TestAllowWithinFraction(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(100, 1.0, WithClock(clock.Now))
+
+	for second := range 3 {
+		allowed := 0
+		for range 100 {
+			if limiter.AllowWithinFraction(0.5) {
+				allowed++
+			}
+			clock.Advance(time.Millisecond)
+		}
+		if allowed != 50 {
+			t.Errorf("Second %d: expected 50 grants at fraction 0.5, got %d", second, allowed)
+		}
+		clock.Advance(900 * time.Millisecond)
+	}
+
+	if !limiter.AllowWithinFraction(1.0) {
+		t.Error("Fraction 1.0 should behave like Allow")
+	}
+	if limiter.AllowWithinFraction(0) {
+		t.Error("Fraction 0 should always deny")
+	}
+	if limiter.AllowWithinFraction(math.NaN()) {
+		t.Error("NaN fraction should always deny")
+	}
+}
+
+func TestBurstHeadroom(t *testing.T) {
+	limiter := NewLimiter(100, 1.5)
+
+	if headroom := limiter.BurstHeadroom(); headroom != 50 {
+		t.Errorf("Expected headroom 50 with full bucket, got %d", headroom)
+	}
+
+	limiter.TryAllow(80)
+	if headroom := limiter.BurstHeadroom(); headroom != 0 {
+		t.Errorf("Expected headroom 0 below maxRPS, got %d", headroom)
+	}
+}
+
+func TestSoftLimit(t *testing.T) {
+	limiter := NewLimiter(100, 1.0, WithSoftLimit(80))
+
+	if !limiter.TryAllow(80) {
+		t.Error("Should allow 80 tokens under soft limit")
+	}
+	if limiter.Allow() {
+		t.Error("Ordinary request should be denied while reserved headroom remains")
+	}
+	if available := limiter.Available(); available != 20 {
+		t.Errorf("Expected 20 reserved tokens, got %d", available)
+	}
+	if limiter.TryAllow(81) {
+		t.Error("Should never allow more tokens than soft limit")
+	}
+	if d := limiter.TimeUntilAvailable(81); d != time.Duration(math.MaxInt64) {
+		t.Errorf("Expected max duration for tokens over soft limit, got %v", d)
+	}
+
+	for i := range 20 {
+		if !limiter.AllowPriority() {
+			t.Errorf("Priority request %d should be allowed from reserved headroom", i)
+		}
+	}
+	if limiter.AllowPriority() {
+		t.Error("Priority request should be denied with empty bucket")
+	}
+}
+
+func TestCanSustain(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(100, 1.0, WithClock(clock.Now), WithGrantTracking())
+
+	limiter.TryAllow(60)
+	clock.Advance(time.Second)
+
+	if rate := limiter.ObservedRate(); rate != 60 {
+		t.Fatalf("Expected observed rate 60, got %d", rate)
+	}
+	if !limiter.CanSustain(40) {
+		t.Error("Should sustain 40 RPS within headroom of 40")
+	}
+	if limiter.CanSustain(41) {
+		t.Error("Should not sustain 41 RPS beyond headroom of 40")
+	}
+
+	clock.Advance(2 * time.Second)
+	if !limiter.CanSustain(100) {
+		t.Error("Should sustain full rate after load stopped")
+	}
+	if !NewLimiter(0, 1.0).CanSustain(math.MaxUint64) {
+		t.Error("Unlimited limiter should sustain any rate")
+	}
+
+	plain := NewLimiter(100, 1.0, WithClock(clock.Now))
+	plain.TryAllow(30)
+	clock.Advance(time.Second)
+	if rate := plain.ObservedRate(); rate != 0 {
+		t.Errorf("Grants before first call should not be counted without WithGrantTracking, got %d", rate)
+	}
+	plain.TryAllow(30)
+	clock.Advance(time.Second)
+	if rate := plain.ObservedRate(); rate != 30 {
+		t.Errorf("Expected observed rate 30 after counting started, got %d", rate)
+	}
+}
+
+func TestWindowReset(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiterCap(1, 10, WithClock(clock.Now), WithWindowReset(time.Second))
+
+	clock.Advance(300 * time.Millisecond)
+	if !limiter.TryAllow(10) {
+		t.Fatal("Should allow full capacity")
+	}
+
+	clock.Advance(600 * time.Millisecond)
+	if tokens := limiter.Available(); tokens != 0 {
+		t.Errorf("Tokens should not refill within window, got %d", tokens)
+	}
+	if wait := limiter.TimeUntilAvailable(1); wait != 100*time.Millisecond {
+		t.Errorf("Expected wait until boundary of 100ms, got %v", wait)
+	}
+
+	clock.Advance(99*time.Millisecond + 999*time.Microsecond)
+	if tokens := limiter.Available(); tokens != 0 {
+		t.Errorf("Tokens should not refill right before boundary, got %d", tokens)
+	}
+
+	clock.Advance(time.Microsecond)
+	if tokens := limiter.Available(); tokens != 10 {
+		t.Errorf("Tokens should snap to capacity at boundary, got %d", tokens)
+	}
+
+	limiter.TryAllow(4)
+	clock.Advance(500 * time.Millisecond)
+	if tokens := limiter.Available(); tokens != 6 {
+		t.Errorf("Expected 6 tokens within window, got %d", tokens)
+	}
+}
+
+func TestMaxGrantsOver(t *testing.T) {
+	limiter := NewLimiter(100, 1.5)
+	limiter.TryAllow(100)
+
+	if grants := limiter.MaxGrantsOver(2500 * time.Millisecond); grants != 300 {
+		t.Errorf("Expected 50 available plus 250 generated, got %d", grants)
+	}
+	if grants := limiter.MaxGrantsOver(0); grants != 50 {
+		t.Errorf("Expected only available tokens over zero window, got %d", grants)
+	}
+	if grants := NewLimiter(0, 1.0).MaxGrantsOver(time.Second); grants != math.MaxUint64 {
+		t.Errorf("Expected unlimited grants for zero maxRPS, got %d", grants)
+	}
+	if grants := NewLimiter(math.MaxUint64/2, 1.0).MaxGrantsOver(time.Hour); grants != math.MaxUint64 {
+		t.Errorf("Expected saturated grants on overflow, got %d", grants)
+	}
+}
+
+func TestTimeUntilAvailable(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	if d := limiter.TimeUntilAvailable(5); d != 0 {
+		t.Errorf("Expected zero wait with full bucket, got %v", d)
+	}
+
+	limiter.TryAllow(10)
+	d := limiter.TimeUntilAvailable(2)
+	if d <= 100*time.Millisecond || d > 200*time.Millisecond {
+		t.Errorf("Expected wait about 200ms for 2 tokens, got %v", d)
+	}
+
+	if d := limiter.TimeUntilAvailable(11); d != time.Duration(math.MaxInt64) {
+		t.Errorf("Expected max duration for tokens over capacity, got %v", d)
+	}
+}
+
+func TestWaitN(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+	limiter.TryAllow(10)
+
+	start := time.Now()
+	if err := limiter.WaitN(context.Background(), 2); err != nil {
+		t.Fatalf("WaitN returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected WaitN to block about 200ms, blocked %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	if err := limiter.WaitN(context.Background(), 11); !errors.Is(err, ErrExceedsCapacity) {
+		t.Errorf("Expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestWaitNTimed(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(100, 1.0, WithClock(clock.Now), WithTimer(clock.After))
+	limiter.TryAllow(100)
+
+	done := make(chan time.Duration)
+	go func() {
+		blocked, err := limiter.WaitNTimed(context.Background(), 10)
+		if err != nil {
+			t.Errorf("WaitNTimed returned error: %v", err)
+		}
+		done <- blocked
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	if blocked := <-done; blocked != 100*time.Millisecond {
+		t.Errorf("Expected 100ms blocked by limiter's clock, got %v", blocked)
+	}
+
+	blocked, err := NewLimiter(100, 1.0).WaitNTimed(context.Background(), 50)
+	if err != nil || blocked != 0 {
+		t.Errorf("Expected zero duration for immediately available tokens, got %v and %v", blocked, err)
+	}
+}
+
+func TestSetMaxRPSWakesWaiters(t *testing.T) {
+	limiter := NewLimiter(1, 1.0)
+	limiter.Allow()
+
+	result := make(chan error, 1)
+	go func() { result <- limiter.Wait(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+
+	limiter.SetMaxRPS(0, 1.0)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Wait returned error: %v", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Waiter should return promptly after limiter becomes unlimited")
+	}
+
+	limiter.SetMaxRPS(1, 1.0)
+	limiter.TryAllow(1)
+	go func() { result <- limiter.Wait(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+
+	limiter.SetMaxRPS(1000, 1.0)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Wait returned error: %v", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Waiter should re-evaluate its wait after rate increase")
+	}
+}
+
+func TestAllowCtx(t *testing.T) {
+	// Clock starts at real time, so deadlines set by it don't expire before the calls.
+	clock := newFakeClock()
+	clock.nanoseconds.Store(time.Now().UnixNano())
+	limiter := NewLimiter(10, 1.0, WithClock(clock.Now))
+	ctx := WithAccounting(context.Background())
+
+	if !limiter.AllowCtx(ctx) {
+		t.Error("Should allow with available tokens")
+	}
+	limiter.TryAllow(9)
+
+	nearExpired, cancel := context.WithDeadline(ctx, clock.Now().Add(50*time.Millisecond))
+	defer cancel()
+	if limiter.AllowCtx(nearExpired) {
+		t.Error("Should deny when token won't free up before deadline")
+	}
+	if limiter.AllowCtx(ctx) {
+		t.Error("Should deny without deadline and without tokens")
+	}
+
+	// Fake clock never advances by itself, so blocking call would never return.
+	withTime, cancel := context.WithDeadline(ctx, clock.Now().Add(time.Hour))
+	defer cancel()
+	if limiter.AllowCtx(withTime) {
+		t.Error("Should not wait for token even if it frees up before deadline")
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	if limiter.AllowCtx(done) {
+		t.Error("Should deny with done ctx even if token is available")
+	}
+	if !limiter.AllowCtx(withTime) {
+		t.Error("Should allow once token is available")
+	}
+
+	if consumed := ConsumedFromContext(ctx); consumed != 2 {
+		t.Errorf("Expected 2 consumed tokens in accounting, got %d", consumed)
+	}
+	if stats := limiter.Stats(); stats.Allowed != 3 || stats.Denied != 4 {
+		t.Errorf("Expected 3 allowed and 4 denied, got %d and %d", stats.Allowed, stats.Denied)
+	}
+
+	grace := NewLimiter(10, 1.0, WithClock(clock.Now), WithOverflowPolicy(OverflowGrace))
+	grace.TryAllow(10)
+	if grace.AllowCtx(nearExpired) {
+		t.Error("Deadline before next token should deny instead of granting on debt")
+	}
+	if !grace.AllowCtx(ctx) {
+		t.Error("Without deadline grace should grant at zero tokens")
+	}
+}
+
+func TestDoOrFallback(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+	limiter.TryAllow(10)
+
+	var called string
+	do := func() error { called = "do"; return nil }
+	fallback := func() error { called = "fallback"; return nil }
+
+	if err := limiter.DoOrFallback(context.Background(), time.Millisecond, do, fallback); err != nil {
+		t.Fatalf("DoOrFallback returned error: %v", err)
+	}
+	if called != "fallback" {
+		t.Errorf("Expected fallback with empty bucket and tiny budget, got %q", called)
+	}
+
+	if err := limiter.DoOrFallback(context.Background(), time.Second, do, fallback); err != nil {
+		t.Fatalf("DoOrFallback returned error: %v", err)
+	}
+	if called != "do" {
+		t.Errorf("Expected do within budget, got %q", called)
+	}
+}
+
+func TestStats(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	limiter.TryAllow(8)
+	limiter.Allow()
+	limiter.TryAllow(5)
+	limiter.Allow()
+
+	stats := limiter.Stats()
+	if stats.Allowed != 3 {
+		t.Errorf("Expected 3 allowed requests, got %d", stats.Allowed)
+	}
+	if stats.Denied != 1 {
+		t.Errorf("Expected 1 denied request, got %d", stats.Denied)
+	}
+	if stats.Tokens != 0 || stats.Capacity != 10 {
+		t.Errorf("Expected 0 tokens of capacity 10, got %d of %d", stats.Tokens, stats.Capacity)
+	}
+}
+
+func TestSwapCounters(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	limiter.TryAllow(10)
+	for range 5 {
+		limiter.Allow()
+	}
+
+	if denied := limiter.SwapDenied(); denied != 5 {
+		t.Errorf("Expected 5 denied, got %d", denied)
+	}
+	if denied := limiter.SwapDenied(); denied != 0 {
+		t.Errorf("Expected 0 denied after swap, got %d", denied)
+	}
+	if allowed := limiter.Stats().Allowed; allowed != 1 {
+		t.Errorf("SwapDenied should not reset allowed, got %d", allowed)
+	}
+	if allowed := limiter.SwapAllowed(); allowed != 1 {
+		t.Errorf("Expected 1 allowed, got %d", allowed)
+	}
+	if allowed := limiter.SwapAllowed(); allowed != 0 {
+		t.Errorf("Expected 0 allowed after swap, got %d", allowed)
+	}
+}
+
+func TestMergeStats(t *testing.T) {
+	clock := newFakeClock()
+	limiters := []*ATLimiter{
+		NewLimiter(10, 1.0, WithClock(clock.Now)),
+		NewLimiter(20, 1.0, WithClock(clock.Now)),
+		NewLimiter(30, 1.0, WithClock(clock.Now)),
+	}
+
+	for i, limiter := range limiters {
+		for range (i + 1) * 10 {
+			limiter.Allow()
+		}
+		for range i + 1 {
+			limiter.Allow()
+		}
+	}
+
+	merged := MergeStats(limiters...)
+	if merged.Allowed != 60 {
+		t.Errorf("Expected 60 allowed in total, got %d", merged.Allowed)
+	}
+	if merged.Denied != 6 {
+		t.Errorf("Expected 6 denied in total, got %d", merged.Denied)
+	}
+	if merged.Tokens != 0 || merged.Capacity != 60 {
+		t.Errorf("Expected 0 tokens of capacity 60, got %d of %d", merged.Tokens, merged.Capacity)
+	}
+
+	if merged := MergeStats(); merged != (Stats{}) {
+		t.Errorf("Expected zero stats without limiters, got %+v", merged)
+	}
+}
+
+func TestStatsLastDenialAt(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(1, 1.0, WithClock(clock.Now))
+
+	limiter.Allow()
+	if at := limiter.Stats().LastDenialAt; !at.IsZero() {
+		t.Errorf("Expected zero LastDenialAt without denials, got %v", at)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	limiter.Allow()
+	if at := limiter.Stats().LastDenialAt; !at.Equal(clock.Now()) {
+		t.Errorf("Expected LastDenialAt %v, got %v", clock.Now(), at)
+	}
+}
+
+func FuzzLimiter(f *testing.F) {
+	f.Add(uint64(0), 1.0, []byte{0, 1, 2, 3, 4})
+	f.Add(uint64(100), 1.5, []byte{1, 1, 1, 2, 3})
+	f.Add(uint64(math.MaxUint64), 1.0, []byte{0, 1, 3, 4})
+	f.Add(uint64(math.MaxUint64), 2.0, []byte{4, 255, 1})
+	f.Add(uint64(10), math.NaN(), []byte{0, 2, 3})
+	f.Add(uint64(10), math.Inf(1), []byte{1, 2, 4})
+	f.Add(uint64(10), math.Inf(-1), []byte{3, 0})
+	f.Add(uint64(1), -5.0, []byte{0, 0, 0})
+	f.Add(uint64(1)<<63, 3.0, []byte{3, 200, 1, 2})
+
+	f.Fuzz(func(t *testing.T, maxRPS uint64, factor float64, ops []byte) {
+		limiter := NewLimiter(maxRPS, factor)
+
+		check := func(op string) {
+			capacity := limiter.GetCapacity()
+			if capacity == 0 {
+				t.Fatalf("Capacity should never be zero after %s", op)
+			}
+			if capacity < limiter.GetMaxRPS() {
+				t.Fatalf("Capacity %d should not be less than maxRPS %d after %s", capacity, limiter.GetMaxRPS(), op)
+			}
+			if tokens := limiter.store.LoadTokens(); tokens > capacity {
+				t.Fatalf("Tokens %d exceed capacity %d after %s", tokens, capacity, op)
+			}
+		}
+		check("NewLimiter")
+
+		for i, op := range ops {
+			arg := uint64(op)
+			if i+1 < len(ops) && ops[i+1] > 200 {
+				arg = math.MaxUint64 - arg
+			}
+
+			switch op % 5 {
+			case 0:
+				limiter.Allow()
+				check("Allow")
+			case 1:
+				if limiter.TryAllow(arg) && limiter.GetMaxRPS() != 0 && arg > limiter.GetCapacity() {
+					t.Fatalf("TryAllow(%d) allowed more than capacity %d", arg, limiter.GetCapacity())
+				}
+				check("TryAllow")
+			case 2:
+				if available := limiter.Available(); available > limiter.GetCapacity() {
+					t.Fatalf("Available %d exceeds capacity %d", available, limiter.GetCapacity())
+				}
+				check("Available")
+			case 3:
+				limiter.SetMaxRPS(maxRPS>>(arg%64), factor*float64(arg%4))
+				check("SetMaxRPS")
+			case 4:
+				if d := limiter.TimeUntilAvailable(arg); d < 0 {
+					t.Fatalf("TimeUntilAvailable(%d) returned negative duration %v", arg, d)
+				}
+				check("TimeUntilAvailable")
+			}
+		}
+	})
+}
+
 func Benchmark_Allow(b *testing.B) {
 	b.Run("atlimiter", func(b *testing.B) {
 		limiter := NewLimiter(1000000, 1.0)
@@ -0,0 +1,205 @@
+package atlimiter
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Interval of capacity auto-tuning
+const autoCapacityInterval = time.Second
+
+var (
+	// ErrNotStarted is returned by Close if background features were configured but Start was never called,
+	// and by Wait, WaitN, WaitNTimed and DoOrFallback if they pace by dynamic rate that isn't tracked.
+	ErrNotStarted = errors.New("atlimiter: background features are configured but limiter was not started")
+	// ErrClosed is returned by Start after Close.
+	ErrClosed = errors.New("atlimiter: limiter is closed")
+)
+
+// - is a private method of ATLimiter that runs fn in a goroutine stopped by Close.
+func (r *ATLimiter) goBackground(fn func()) {
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		fn()
+	}()
+}
+
+// - launches background goroutines of features configured by options, e.g. WithPeriodicLog and WithDynamicRate.
+//
+// Background features don't run until Start is called. Repeated calls are no-op, so goroutines are started exactly once.
+// Returns ErrClosed if limiter is already closed.
+func (r *ATLimiter) Start() error {
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+
+	if r.closed.Load() {
+		return ErrClosed
+	}
+	if r.started.Load() {
+		return nil
+	}
+	r.started.Store(true)
+
+	if r.logger != nil && r.logInterval > 0 {
+		r.goBackground(r.logSummaries)
+	}
+	if r.rateFn != nil && r.rateInterval > 0 {
+		r.goBackground(r.trackDynamicRate)
+	}
+	if r.autoCapacity {
+		r.goBackground(r.tuneCapacity)
+	}
+	return nil
+}
+
+// - is a private method of ATLimiter that reports if any background feature is configured.
+func (r *ATLimiter) hasBackground() bool {
+	return (r.logger != nil && r.logInterval > 0) || (r.rateFn != nil && r.rateInterval > 0) || r.autoCapacity
+}
+
+// - stops background goroutines launched by Start and waits for them to exit.
+//
+// Limiter stays usable after Close, only background features stop. Repeated calls are no-op.
+// Returns ErrNotStarted if background features were configured but never started, so they silently didn't work.
+func (r *ATLimiter) Close() error {
+	r.lifecycleMu.Lock()
+	if !r.closed.Load() {
+		r.closed.Store(true)
+		close(r.done)
+	}
+	r.lifecycleMu.Unlock()
+
+	r.workers.Wait()
+	if r.hasBackground() && !r.started.Load() {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+// - is a private method of ATLimiter that returns ErrNotStarted if WithDynamicRate is configured
+// but Start was never called, so waiting would silently be paced by rate that doesn't follow the signal.
+//
+// Other background features don't affect waiting. After Close rate isn't tracked deliberately, so it's no error.
+func (r *ATLimiter) checkDynamicRate() error {
+	if r.rateFn != nil && r.rateInterval > 0 && !r.started.Load() && !r.closed.Load() {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+// - is a private method of ATLimiter that logs summary of limiter's traffic every logInterval.
+//
+// Intervals without traffic are skipped to avoid noise.
+func (r *ATLimiter) logSummaries() {
+	previous := r.Stats()
+	previousGranted := r.grantedTokens.Load()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.after(r.logInterval):
+		}
+
+		current := r.Stats()
+		allowed := counterDelta(current.Allowed, previous.Allowed)
+		denied := counterDelta(current.Denied, previous.Denied)
+		granted := r.grantedTokens.Load()
+		grantedDelta := granted - previousGranted
+		previous, previousGranted = current, granted
+
+		if allowed == 0 && denied == 0 {
+			continue
+		}
+
+		rate := float64(allowed) / r.logInterval.Seconds()
+		utilization := 0.0
+		if maxRPS := r.GetMaxRPS(); maxRPS > 0 {
+			utilization = float64(grantedDelta) / r.logInterval.Seconds() / float64(maxRPS)
+		}
+
+		r.logger.Info("atlimiter summary",
+			"allowed", allowed,
+			"denied", denied,
+			"utilization", utilization,
+			"rate", rate,
+		)
+	}
+}
+
+// - is a private function that returns growth of counter since previous value.
+//
+// Counter that went down was reset by SwapAllowed or SwapDenied, so all its current value is growth.
+func counterDelta(current, previous uint64) uint64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// - is a private method of ATLimiter that applies maxRPS returned by rateFn every rateInterval.
+//
+// Current capacity factor is kept, ticks that don't change maxRPS or where rateFn panicked are skipped.
+func (r *ATLimiter) trackDynamicRate() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.after(r.rateInterval):
+		}
+
+		var newMaxRPS uint64
+		if !r.callHook("dynamic rate", func() { newMaxRPS = r.rateFn() }) {
+			continue
+		}
+		if newMaxRPS != r.GetMaxRPS() {
+			r.SetMaxRPS(newMaxRPS, r.GetCapacityFactor())
+		}
+	}
+}
+
+// - is a private method of ATLimiter that adjusts capacity by requests decided every autoCapacityInterval.
+func (r *ATLimiter) tuneCapacity() {
+	previousAllowed, previousDenied := r.allowed.Load(), r.denied.Load()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.after(autoCapacityInterval):
+		}
+
+		allowed, denied := r.allowed.Load(), r.denied.Load()
+		r.adjustCapacity(counterDelta(allowed, previousAllowed), counterDelta(denied, previousDenied))
+		previousAllowed, previousDenied = allowed, denied
+	}
+}
+
+// - is a private method of ATLimiter that makes one bounded step of capacity towards targetDenyRate.
+//
+// Capacity grows by a quarter if fraction of denied requests is above target and shrinks by a tenth
+// if it's below half of target. It only grows while below the upper bound and only shrinks while above the lower one,
+// lower bound is at least current maxRPS, so capacity set by SetMaxRPS outside of bounds isn't pushed in the wrong direction.
+func (r *ATLimiter) adjustCapacity(allowed, denied uint64) {
+	maxRPS := atomic.LoadUint64(&r.maxRPS)
+	if r.smoothing || maxRPS == 0 || allowed+denied == 0 {
+		return
+	}
+
+	lower := max(r.minCapacity, maxRPS)
+	upper := max(r.maxCapacity, lower)
+
+	capacity := atomic.LoadUint64(&r.capacity)
+	denyRate := float64(denied) / float64(allowed+denied)
+	newCapacity := capacity
+	switch {
+	case denyRate > r.targetDenyRate && capacity < upper:
+		newCapacity = min(saturatingAdd(capacity, max(capacity/4, 1)), upper)
+	case denyRate < r.targetDenyRate/2 && capacity > lower:
+		newCapacity = max(capacity-max(capacity/10, 1), lower)
+	}
+
+	if newCapacity != capacity {
+		r.setCapacity(newCapacity)
+	}
+}
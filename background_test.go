@@ -0,0 +1,313 @@
+package atlimiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// - is a slog.Handler that collects records for assertions.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, record)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+func (h *recordingHandler) snapshot() []slog.Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]slog.Record(nil), h.records...)
+}
+
+func TestPeriodicLog(t *testing.T) {
+	clock := newFakeClock()
+	handler := &recordingHandler{}
+	limiter := NewLimiter(10, 1.0,
+		WithClock(clock.Now),
+		WithTimer(clock.After),
+		WithPeriodicLog(time.Second, slog.New(handler)),
+	)
+	limiter.Start()
+	defer limiter.Close()
+
+	// Each Advance fires the pending timer, the next one is created after the interval is handled.
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	if records := handler.snapshot(); len(records) != 0 {
+		t.Fatalf("Intervals without traffic should not be logged, got %d records", len(records))
+	}
+
+	limiter.TryAllow(8)
+	limiter.TryAllow(5)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	records := handler.snapshot()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 summary record, got %d", len(records))
+	}
+	if records[0].Level != slog.LevelInfo {
+		t.Errorf("Expected INFO level, got %v", records[0].Level)
+	}
+
+	attrs := map[string]slog.Value{}
+	records[0].Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+		return true
+	})
+	if allowed := attrs["allowed"].Uint64(); allowed != 1 {
+		t.Errorf("Expected 1 allowed in summary, got %d", allowed)
+	}
+	if denied := attrs["denied"].Uint64(); denied != 1 {
+		t.Errorf("Expected 1 denied in summary, got %d", denied)
+	}
+	if rate := attrs["rate"].Float64(); rate != 1 {
+		t.Errorf("Expected rate 1, got %v", rate)
+	}
+	if utilization := attrs["utilization"].Float64(); utilization != 0.8 {
+		t.Errorf("Expected utilization 0.8 by 8 granted tokens, got %v", utilization)
+	}
+}
+
+func TestClose(t *testing.T) {
+	limiter := NewLimiter(10, 1.0, WithPeriodicLog(time.Millisecond, slog.New(&recordingHandler{})))
+	limiter.Start()
+
+	if err := limiter.Close(); err != nil {
+		t.Errorf("Expected no error on Close, got %v", err)
+	}
+	if err := limiter.Close(); err != nil {
+		t.Errorf("Repeated Close should be no-op, got %v", err)
+	}
+	if err := limiter.Start(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed on Start after Close, got %v", err)
+	}
+
+	if !limiter.Allow() {
+		t.Error("Limiter should stay usable after Close")
+	}
+}
+
+func TestStart(t *testing.T) {
+	limiter := NewLimiter(10, 1.0,
+		WithPeriodicLog(time.Hour, slog.New(&recordingHandler{})),
+		WithDynamicRate(time.Hour, func() uint64 { return 10 }),
+	)
+
+	before := runtime.NumGoroutine()
+	if err := limiter.Start(); err != nil {
+		t.Fatalf("Expected no error on Start, got %v", err)
+	}
+	if err := limiter.Start(); err != nil {
+		t.Fatalf("Repeated Start should be no-op, got %v", err)
+	}
+	if delta := runtime.NumGoroutine() - before; delta != 2 {
+		t.Errorf("Expected 2 background goroutines, got %d", delta)
+	}
+
+	limiter.Close()
+	// Workers are done when Close returns, but their goroutines may still be exiting.
+	waitFor(t, func() bool { return runtime.NumGoroutine() == before })
+}
+
+func TestCloseNotStarted(t *testing.T) {
+	limiter := NewLimiter(10, 1.0, WithDynamicRate(time.Millisecond, func() uint64 { return 20 }))
+
+	if err := limiter.Wait(context.Background()); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Expected ErrNotStarted from Wait, got %v", err)
+	}
+	fallback := func() error { return nil }
+	if err := limiter.DoOrFallback(context.Background(), time.Second, fallback, fallback); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Expected ErrNotStarted from DoOrFallback, got %v", err)
+	}
+	if err := limiter.Close(); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Expected ErrNotStarted, got %v", err)
+	}
+	if maxRPS := limiter.GetMaxRPS(); maxRPS != 10 {
+		t.Errorf("Background features should not run without Start, got maxRPS %d", maxRPS)
+	}
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Errorf("Limiter should stay usable after Close, got %v", err)
+	}
+
+	if err := NewLimiter(10, 1.0).Close(); err != nil {
+		t.Errorf("Limiter without background features doesn't need Start, got %v", err)
+	}
+	unrelated := NewLimiter(10, 1.0,
+		WithPeriodicLog(time.Hour, slog.New(&recordingHandler{})),
+		WithAutoCapacity(0.05, 10, 100),
+	)
+	if err := unrelated.Wait(context.Background()); err != nil {
+		t.Errorf("Waiting doesn't depend on periodic log and auto-capacity, got %v", err)
+	}
+
+	started := NewLimiter(10, 1.0, WithDynamicRate(time.Hour, func() uint64 { return 10 }))
+	started.Start()
+	defer started.Close()
+	if err := started.Wait(context.Background()); err != nil {
+		t.Errorf("Started limiter should wait without error, got %v", err)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition was not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDynamicRate(t *testing.T) {
+	var signal atomic.Uint64
+	signal.Store(100)
+
+	limiter := NewLimiter(100, 2.0, WithDynamicRate(5*time.Millisecond, signal.Load))
+	limiter.Start()
+	defer limiter.Close()
+
+	signal.Store(50)
+	waitFor(t, func() bool { return limiter.GetMaxRPS() == 50 })
+	if capacity := limiter.GetCapacity(); capacity != 100 {
+		t.Errorf("Capacity factor should be kept, expected capacity 100, got %d", capacity)
+	}
+
+	signal.Store(200)
+	waitFor(t, func() bool { return limiter.GetMaxRPS() == 200 })
+	if capacity := limiter.GetCapacity(); capacity != 400 {
+		t.Errorf("Expected capacity 400, got %d", capacity)
+	}
+
+	limiter.Close()
+	signal.Store(10)
+	time.Sleep(20 * time.Millisecond)
+	if maxRPS := limiter.GetMaxRPS(); maxRPS != 200 {
+		t.Errorf("Signal should not be applied after Close, got maxRPS %d", maxRPS)
+	}
+}
+
+func TestAutoCapacity(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(10, 1.0, WithClock(clock.Now), WithAutoCapacity(0.05, 10, 100))
+
+	var firstDenied uint64
+	for round := range 10 {
+		clock.Advance(2 * time.Second)
+		for range 20 {
+			limiter.Allow()
+		}
+
+		allowed, denied := limiter.SwapAllowed(), limiter.SwapDenied()
+		if round == 0 {
+			firstDenied = denied
+		}
+		limiter.adjustCapacity(allowed, denied)
+	}
+
+	if capacity := limiter.GetCapacity(); capacity < 18 || capacity > 100 {
+		t.Errorf("Expected capacity to grow towards burst of 20, got %d", capacity)
+	}
+	if firstDenied == 0 {
+		t.Error("Bursts should be denied before tuning")
+	}
+
+	for range 50 {
+		limiter.adjustCapacity(0, 100)
+	}
+	if capacity := limiter.GetCapacity(); capacity != 100 {
+		t.Errorf("Expected capacity bounded by maxCapacity, got %d", capacity)
+	}
+	for range 50 {
+		limiter.adjustCapacity(100, 0)
+	}
+	if capacity := limiter.GetCapacity(); capacity != 10 {
+		t.Errorf("Expected capacity bounded by minCapacity, got %d", capacity)
+	}
+}
+
+func TestAutoCapacityLoop(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(10, 1.0, WithClock(clock.Now), WithTimer(clock.After), WithAutoCapacity(0.05, 5, 30))
+	limiter.Start()
+	defer limiter.Close()
+
+	// Each Advance fires the pending timer, the next one is created after the interval is handled.
+	for range 10 {
+		clock.BlockUntil(1)
+		for range 20 {
+			limiter.Allow()
+		}
+		clock.Advance(time.Second)
+	}
+	clock.BlockUntil(1)
+	if capacity := limiter.GetCapacity(); capacity != 30 {
+		t.Errorf("Expected capacity to grow up to maxCapacity under denied bursts, got %d", capacity)
+	}
+
+	for range 30 {
+		limiter.Allow()
+		clock.Advance(time.Second)
+		clock.BlockUntil(1)
+	}
+	if capacity := limiter.GetCapacity(); capacity != 10 {
+		t.Errorf("Expected capacity to shrink down to maxRPS above minCapacity without denials, got %d", capacity)
+	}
+}
+
+func TestAutoCapacityBounds(t *testing.T) {
+	limiter := NewLimiter(100, 1.0, WithAutoCapacity(0.1, 1, 1000))
+	for range 50 {
+		limiter.adjustCapacity(100, 0)
+	}
+	if capacity := limiter.GetCapacity(); capacity != 100 {
+		t.Errorf("Capacity should not be tuned below maxRPS, got %d", capacity)
+	}
+	if factor := limiter.GetCapacityFactor(); factor != 1.0 {
+		t.Errorf("Expected capacity factor 1.0, got %v", factor)
+	}
+
+	below := NewLimiter(10, 1.0, WithAutoCapacity(0.05, 50, 100))
+	below.adjustCapacity(100, 0)
+	if capacity := below.GetCapacity(); capacity != 10 {
+		t.Errorf("Shrinking should never raise capacity below minCapacity, got %d", capacity)
+	}
+
+	above := NewLimiter(10, 20.0, WithAutoCapacity(0.05, 10, 100))
+	above.adjustCapacity(0, 100)
+	if capacity := above.GetCapacity(); capacity != 200 {
+		t.Errorf("Growing should never lower capacity above maxCapacity, got %d", capacity)
+	}
+}
+
+func TestAutoCapacityInvalidBounds(t *testing.T) {
+	for _, opt := range []Option{
+		WithAutoCapacity(0, 10, 100),
+		WithAutoCapacity(1, 10, 100),
+		WithAutoCapacity(0.05, 0, 100),
+		WithAutoCapacity(0.05, 100, 10),
+	} {
+		if limiter := NewLimiter(10, 1.0, opt); limiter.autoCapacity {
+			t.Errorf("Invalid settings should be ignored, got bounds %d..%d", limiter.minCapacity, limiter.maxCapacity)
+		}
+	}
+}
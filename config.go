@@ -0,0 +1,61 @@
+package atlimiter
+
+import (
+	"math"
+	"time"
+)
+
+// - is a set of base parameters of limiter.
+type LimiterConfig struct {
+	// Max quantity of requests per second
+	MaxRPS uint64
+	// Capacity increase multiplier
+	CapacityFactor float64
+}
+
+// - returns config of limiter whose waits at maxRPS stay within maxWait.
+//
+// Draining a full container takes capacity / maxRPS seconds to refill, so capacity factor equals maxWait in seconds
+// and WaitN of any admissible quantity of tokens from empty container blocks at most maxWait.
+// Capacity can't be less than maxRPS, so for maxWait under a second factor is 1 and only WaitN
+// of up to maxRPS * maxWait tokens stays within it.
+func SizeForLatency(maxRPS uint64, maxWait time.Duration) LimiterConfig {
+	return LimiterConfig{
+		MaxRPS:         maxRPS,
+		CapacityFactor: max(maxWait.Seconds(), 1.0),
+	}
+}
+
+// - creates limiter with parameters of config and optional settings.
+func (c LimiterConfig) Build(opts ...Option) *ATLimiter {
+	return NewLimiter(c.MaxRPS, c.CapacityFactor, opts...)
+}
+
+// - returns current base parameters of limiter, e.g. to compare them with desired ones.
+func (r *ATLimiter) Config() LimiterConfig {
+	return LimiterConfig{
+		MaxRPS:         r.GetMaxRPS(),
+		CapacityFactor: r.GetCapacityFactor(),
+	}
+}
+
+// - reports if configs have the same parameters.
+//
+// Capacity factors are compared bitwise, so NaN equals NaN and config can be compared with itself.
+func (c LimiterConfig) Equal(other LimiterConfig) bool {
+	return len(c.Diff(other)) == 0
+}
+
+// - returns names of fields that differ between configs, nil if they are equal.
+//
+// It's designed to detect drift of live limiter from desired state, e.g. after SetMaxRPS at runtime.
+func (c LimiterConfig) Diff(other LimiterConfig) []string {
+	var fields []string
+	if c.MaxRPS != other.MaxRPS {
+		fields = append(fields, "MaxRPS")
+	}
+	if math.Float64bits(c.CapacityFactor) != math.Float64bits(other.CapacityFactor) {
+		fields = append(fields, "CapacityFactor")
+	}
+	return fields
+}
@@ -0,0 +1,58 @@
+package atlimiter
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigDiff(t *testing.T) {
+	limiter := NewLimiter(10, 1.5)
+	original := limiter.Config()
+
+	if original != (LimiterConfig{MaxRPS: 10, CapacityFactor: 1.5}) {
+		t.Fatalf("Unexpected config %+v", original)
+	}
+	if !original.Equal(limiter.Config()) {
+		t.Error("Config should equal itself before changes")
+	}
+
+	limiter.SetMaxRPS(20, 1.5)
+	if diff := original.Diff(limiter.Config()); !slices.Equal(diff, []string{"MaxRPS"}) {
+		t.Errorf("Expected MaxRPS drift, got %v", diff)
+	}
+
+	limiter.SetMaxRPS(20, 2.0)
+	if diff := original.Diff(limiter.Config()); !slices.Equal(diff, []string{"MaxRPS", "CapacityFactor"}) {
+		t.Errorf("Expected MaxRPS and CapacityFactor drift, got %v", diff)
+	}
+	if original.Equal(limiter.Config()) {
+		t.Error("Drifted config should not be equal")
+	}
+
+	nan := LimiterConfig{MaxRPS: 10, CapacityFactor: math.NaN()}
+	if !nan.Equal(nan) {
+		t.Error("Config with NaN factor should equal itself")
+	}
+}
+
+func TestSizeForLatency(t *testing.T) {
+	for _, maxWait := range []time.Duration{3 * time.Second, 1500 * time.Millisecond, time.Second} {
+		config := SizeForLatency(100, maxWait)
+		clock := newFakeClock()
+		limiter := config.Build(WithClock(clock.Now))
+
+		capacity := limiter.GetCapacity()
+		if !limiter.TryAllow(capacity) {
+			t.Fatalf("Should drain full container of %d tokens", capacity)
+		}
+		if wait := limiter.TimeUntilAvailable(capacity); wait > maxWait {
+			t.Errorf("Expected refill of full container within %v, got %v", maxWait, wait)
+		}
+	}
+
+	if config := SizeForLatency(100, 100*time.Millisecond); config.CapacityFactor != 1.0 {
+		t.Errorf("Expected capacity factor clamped to 1, got %v", config.CapacityFactor)
+	}
+}
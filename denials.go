@@ -0,0 +1,63 @@
+package atlimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// - is a context of denied request captured for debugging.
+type DenialSample struct {
+	// Time of denial by limiter's clock
+	At time.Time
+	// Quantity of tokens requested
+	Cost uint64
+}
+
+// - is a bounded ring buffer of denial samples, the newest overwrites the oldest.
+type denialRing struct {
+	mu      sync.Mutex
+	samples []DenialSample
+	next    int
+	full    bool
+}
+
+// - is a constructor of denialRing copies.
+func newDenialRing(size int) *denialRing {
+	return &denialRing{samples: make([]DenialSample, size)}
+}
+
+// - puts sample into the ring overwriting the oldest one if the ring is full.
+func (d *denialRing) add(sample DenialSample) {
+	d.mu.Lock()
+	d.samples[d.next] = sample
+	d.next++
+	if d.next == len(d.samples) {
+		d.next = 0
+		d.full = true
+	}
+	d.mu.Unlock()
+}
+
+// - returns copy of samples from the oldest to the newest.
+func (d *denialRing) snapshot() []DenialSample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.full {
+		return append([]DenialSample(nil), d.samples[:d.next]...)
+	}
+
+	result := make([]DenialSample, 0, len(d.samples))
+	result = append(result, d.samples[d.next:]...)
+	return append(result, d.samples[:d.next]...)
+}
+
+// - returns recent denials from the oldest to the newest.
+//
+// Buffer size is set by WithDenialSampling, without it returns nil.
+func (r *ATLimiter) RecentDenials() []DenialSample {
+	if r.denials == nil {
+		return nil
+	}
+	return r.denials.snapshot()
+}
@@ -0,0 +1,39 @@
+package atlimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentDenials(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(10, 1.0, WithClock(clock.Now), WithDenialSampling(3))
+	limiter.TryAllow(10)
+
+	if denials := limiter.RecentDenials(); len(denials) != 0 {
+		t.Fatalf("Expected no denials, got %d", len(denials))
+	}
+
+	start := clock.Now()
+	for cost := uint64(1); cost <= 5; cost++ {
+		limiter.TryAllow(cost)
+		clock.Advance(time.Millisecond)
+	}
+
+	denials := limiter.RecentDenials()
+	if len(denials) != 3 {
+		t.Fatalf("Buffer should be capped at 3 samples, got %d", len(denials))
+	}
+	for i, sample := range denials {
+		if expected := uint64(i + 3); sample.Cost != expected {
+			t.Errorf("Sample %d: expected cost %d, got %d", i, expected, sample.Cost)
+		}
+		if expected := start.Add(time.Duration(i+2) * time.Millisecond); !sample.At.Equal(expected) {
+			t.Errorf("Sample %d: expected time %v, got %v", i, expected, sample.At)
+		}
+	}
+
+	if denials := NewLimiter(10, 1.0).RecentDenials(); denials != nil {
+		t.Errorf("Sampling should be disabled by default, got %v", denials)
+	}
+}
@@ -0,0 +1,41 @@
+package atlimiter
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// - is returned by PublishExpvar when expvar with the same name is already published.
+var ErrExpvarExists = errors.New("atlimiter: expvar with this name is already published")
+
+// Guards check-then-publish sequence, because expvar.Publish panics on duplicate names.
+var expvarMu sync.Mutex
+
+// - publishes limiter's live state to expvar under the given name.
+//
+// Published value is a JSON object with tokens, capacity, maxRPS, allowed and denied fields.
+// It's an expvar.Func, so values are read fresh on each scrape of /debug/vars.
+// If name is already taken returns ErrExpvarExists instead of panicking.
+func (r *ATLimiter) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return ErrExpvarExists
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		stats := r.Stats()
+		return map[string]uint64{
+			"tokens":   stats.Tokens,
+			"capacity": stats.Capacity,
+			"maxRPS":   atomic.LoadUint64(&r.maxRPS),
+			"allowed":  stats.Allowed,
+			"denied":   stats.Denied,
+		}
+	}))
+
+	return nil
+}
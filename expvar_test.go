@@ -0,0 +1,43 @@
+package atlimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	// Expvar can't be unpublished, so every run needs its own name
+	name := uniqueName("atlimiter_test")
+	if err := limiter.PublishExpvar(name); err != nil {
+		t.Fatalf("PublishExpvar returned error: %v", err)
+	}
+
+	limiter.TryAllow(10)
+	limiter.Allow()
+
+	var state map[string]uint64
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &state); err != nil {
+		t.Fatalf("Failed to decode expvar JSON: %v", err)
+	}
+
+	expected := map[string]uint64{
+		"tokens":   0,
+		"capacity": 10,
+		"maxRPS":   10,
+		"allowed":  1,
+		"denied":   1,
+	}
+	for key, value := range expected {
+		if state[key] != value {
+			t.Errorf("Expected %s %d, got %d", key, value, state[key])
+		}
+	}
+
+	if err := NewLimiter(1, 1.0).PublishExpvar(name); !errors.Is(err, ErrExpvarExists) {
+		t.Errorf("Expected ErrExpvarExists on duplicate name, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package atlimiter
+
+import (
+	"context"
+	"slices"
+)
+
+// - is a goroutine blocked in Wait in fair waiting mode.
+type waiter struct {
+	// Closed when waiter becomes head of the queue
+	turn chan struct{}
+}
+
+// - is a private method of ATLimiter that waits for tokens in FIFO order.
+//
+// Only the head of the queue sleeps until tokens refill, the rest block on their turn channels.
+// So released tokens are handed to waiters in arrival order and only one goroutine wakes up per refill.
+// Reports if the caller blocked, either in the queue or waiting for tokens.
+func (r *ATLimiter) waitFair(ctx context.Context, tokensCount uint64) (bool, error) {
+	w := &waiter{turn: make(chan struct{})}
+
+	r.waitMu.Lock()
+	r.waiters = append(r.waiters, w)
+	queued := len(r.waiters) > 1
+	if !queued {
+		close(w.turn)
+	}
+	r.waitMu.Unlock()
+
+	defer r.leaveQueue(w)
+
+	select {
+	case <-w.turn:
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+
+	blocked, err := r.wait(ctx, tokensCount)
+	return queued || blocked, err
+}
+
+// - is a private method of ATLimiter that removes waiter from the queue and passes turn to the next one.
+func (r *ATLimiter) leaveQueue(w *waiter) {
+	r.waitMu.Lock()
+	defer r.waitMu.Unlock()
+
+	i := slices.Index(r.waiters, w)
+	if i < 0 {
+		return
+	}
+
+	r.waiters[i] = nil
+	r.waiters = slices.Delete(r.waiters, i, i+1)
+	if i == 0 && len(r.waiters) > 0 {
+		close(r.waiters[0].turn)
+	}
+}
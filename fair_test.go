@@ -0,0 +1,100 @@
+package atlimiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func queueLen(limiter *ATLimiter) int {
+	limiter.waitMu.Lock()
+	defer limiter.waitMu.Unlock()
+	return len(limiter.waiters)
+}
+
+func TestFairWaiting(t *testing.T) {
+	// Every sleep of a waiter creates a timer, so counting timers counts wakeups.
+	clock := newFakeClock()
+	var timers atomic.Int64
+	limiter := NewLimiter(100, 1.0, WithFairWaiting(), WithClock(clock.Now), WithTimer(func(d time.Duration) <-chan time.Time {
+		timers.Add(1)
+		return clock.After(d)
+	}))
+	limiter.TryAllow(100)
+
+	const waiters = 10
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	for i := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Errorf("Wait %d returned error: %v", i, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}()
+
+		for queueLen(limiter) < i+1 {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+
+	// One token is generated per step, head of the queue takes it and passes turn to the next waiter.
+	for range waiters {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, id := range order {
+		if id != i {
+			t.Fatalf("Waiters should be served in FIFO order, got %v", order)
+		}
+	}
+	if wakeups := timers.Load(); wakeups > waiters {
+		t.Errorf("Only head of the queue should sleep, expected at most %d wakeups, got %d", waiters, wakeups)
+	}
+	if length := queueLen(limiter); length != 0 {
+		t.Errorf("Queue should be empty after all waiters are served, got %d", length)
+	}
+}
+
+func TestFairWaitingCancel(t *testing.T) {
+	limiter := NewLimiter(10, 1.0, WithFairWaiting())
+	limiter.TryAllow(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	head := make(chan error, 1)
+	go func() { head <- limiter.Wait(ctx) }()
+	for queueLen(limiter) < 1 {
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	next := make(chan error, 1)
+	go func() { next <- limiter.Wait(context.Background()) }()
+	for queueLen(limiter) < 2 {
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	cancel()
+	if err := <-head; err == nil {
+		t.Error("Cancelled head waiter should return error")
+	}
+
+	select {
+	case err := <-next:
+		if err != nil {
+			t.Errorf("Next waiter returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next waiter should get turn after head is cancelled")
+	}
+}
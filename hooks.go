@@ -0,0 +1,28 @@
+package atlimiter
+
+import "log/slog"
+
+// - is a private method of ATLimiter that calls user-supplied hook recovering from its panic.
+//
+// Recovered panic is logged (to logger of WithPeriodicLog or default slog logger) and counted in Stats().HookPanics,
+// so a buggy callback can't crash the caller or limiter's background goroutine.
+// With WithStrictHooks panic is propagated. Returns false if hook panicked.
+// Callers check hook for nil before, so limiters without hooks don't pay for deferred recover.
+func (r *ATLimiter) callHook(name string, hook func()) (completed bool) {
+	if !r.strictHooks {
+		defer func() {
+			if p := recover(); p != nil {
+				r.hookPanics.Add(1)
+
+				logger := r.logger
+				if logger == nil {
+					logger = slog.Default()
+				}
+				logger.Error("atlimiter hook panicked", "hook", name, "panic", p)
+			}
+		}()
+	}
+
+	hook()
+	return true
+}
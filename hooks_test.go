@@ -0,0 +1,112 @@
+package atlimiter
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHookPanicRecovered(t *testing.T) {
+	var calls atomic.Uint64
+	limiter := NewLimiter(10, 1.0,
+		WithPeriodicLog(time.Hour, slog.New(&recordingHandler{})),
+		WithDynamicRate(time.Millisecond, func() uint64 {
+			if calls.Add(1) <= 3 {
+				panic("broken signal")
+			}
+			return 20
+		}),
+	)
+	limiter.Start()
+	defer limiter.Close()
+
+	waitFor(t, func() bool { return limiter.GetMaxRPS() == 20 })
+
+	if panics := limiter.Stats().HookPanics; panics != 3 {
+		t.Errorf("Expected 3 recovered hook panics, got %d", panics)
+	}
+	if !limiter.Allow() {
+		t.Error("Limiter should stay functional after hook panics")
+	}
+}
+
+func TestStrictHooks(t *testing.T) {
+	limiter := NewLimiter(1, 1.0, WithStrictHooks(), WithOnEmpty(func() { panic("broken hook") }))
+
+	defer func() {
+		if recovered := recover(); recovered != "broken hook" {
+			t.Errorf("Hook panic should propagate from Allow with WithStrictHooks, got %v", recovered)
+		}
+		if panics := limiter.Stats().HookPanics; panics != 0 {
+			t.Errorf("Strict hooks should not count recovered panics, got %d", panics)
+		}
+	}()
+
+	// Taking the last token fires onEmpty.
+	limiter.Allow()
+}
+
+func TestSoftRate(t *testing.T) {
+	clock := newFakeClock()
+	var warnings atomic.Uint64
+	limiter := NewLimiter(100, 1.0, WithClock(clock.Now), WithSoftRate(80, func() { warnings.Add(1) }))
+
+	for second := range 3 {
+		for range 90 {
+			if !limiter.Allow() {
+				t.Fatalf("Second %d: traffic below maxRPS should not be denied", second)
+			}
+			clock.Advance(10 * time.Millisecond)
+		}
+		clock.Advance(100 * time.Millisecond)
+
+		if count := warnings.Load(); count != uint64(second+1) {
+			t.Errorf("Second %d: expected warning once per second, got %d warnings in total", second, count)
+		}
+	}
+
+	for range 70 {
+		limiter.Allow()
+		clock.Advance(10 * time.Millisecond)
+	}
+	if count := warnings.Load(); count != 3 {
+		t.Errorf("Traffic below soft rate should not fire warning, got %d warnings in total", count)
+	}
+}
+
+func TestEdgeHooks(t *testing.T) {
+	clock := newFakeClock()
+	var empty, recovered atomic.Uint64
+	limiter := NewLimiter(10, 1.0, WithClock(clock.Now),
+		WithOnEmpty(func() { empty.Add(1) }),
+		WithOnRecovered(func() { recovered.Add(1) }),
+	)
+
+	limiter.TryAllow(5)
+	if empty.Load() != 0 {
+		t.Error("OnEmpty should not fire while tokens remain")
+	}
+	for range 10 {
+		limiter.Allow()
+	}
+	if count := empty.Load(); count != 1 {
+		t.Errorf("Expected OnEmpty once when draining, got %d", count)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	limiter.Available()
+	clock.Advance(300 * time.Millisecond)
+	limiter.Available()
+	if count := recovered.Load(); count != 1 {
+		t.Errorf("Expected OnRecovered once when refilling, got %d", count)
+	}
+
+	limiter.TryAllow(4)
+	if count := empty.Load(); count != 2 {
+		t.Errorf("Expected OnEmpty on second transition, got %d", count)
+	}
+	if count := recovered.Load(); count != 1 {
+		t.Errorf("OnRecovered should not fire without refill, got %d", count)
+	}
+}
@@ -0,0 +1,44 @@
+package atlimiter
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// - wraps next handler with limiter, denied requests get 429 Too Many Requests.
+//
+// Every response carries RateLimit-Limit (maxRPS), RateLimit-Remaining (available tokens after decision)
+// and RateLimit-Reset (seconds until container is full again) headers from IETF RateLimit draft,
+// so well-behaved clients can pace themselves. Denied responses carry Retry-After with seconds until next token.
+// Unlimited limiter (maxRPS equals zero) passes all requests without headers.
+func Middleware(limiter *ATLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		allowed := limiter.Allow()
+
+		maxRPS := atomic.LoadUint64(&limiter.maxRPS)
+		if maxRPS != 0 {
+			capacity := atomic.LoadUint64(&limiter.capacity)
+			remaining := min(limiter.store.LoadTokens(), capacity)
+			missing := capacity - remaining
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.FormatUint(maxRPS, 10))
+			header.Set("RateLimit-Remaining", strconv.FormatUint(remaining, 10))
+			header.Set("RateLimit-Reset", strconv.FormatUint(uint64(math.Ceil(float64(missing)/float64(maxRPS))), 10))
+
+			if !allowed {
+				retryAfter := math.Ceil(limiter.TimeUntilAvailable(1).Seconds())
+				header.Set("Retry-After", strconv.FormatUint(uint64(max(retryAfter, 1)), 10))
+			}
+		}
+
+		if !allowed {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
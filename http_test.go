@@ -0,0 +1,74 @@
+package atlimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	limiter := NewLimiter(10, 2.0, WithClock(newFakeClock().Now))
+	handler := Middleware(limiter, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder
+	}
+
+	headerUint := func(t *testing.T, recorder *httptest.ResponseRecorder, name string) uint64 {
+		t.Helper()
+		value, err := strconv.ParseUint(recorder.Header().Get(name), 10, 64)
+		if err != nil {
+			t.Fatalf("Header %s should be a number, got %q", name, recorder.Header().Get(name))
+		}
+		return value
+	}
+
+	recorder := serve()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", recorder.Code)
+	}
+	if limit := headerUint(t, recorder, "RateLimit-Limit"); limit != 10 {
+		t.Errorf("Expected RateLimit-Limit 10, got %d", limit)
+	}
+	if remaining := headerUint(t, recorder, "RateLimit-Remaining"); remaining != 19 {
+		t.Errorf("Expected RateLimit-Remaining 19, got %d", remaining)
+	}
+	if reset := headerUint(t, recorder, "RateLimit-Reset"); reset != 1 {
+		t.Errorf("Expected RateLimit-Reset 1, got %d", reset)
+	}
+
+	limiter.TryAllow(19)
+	recorder = serve()
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", recorder.Code)
+	}
+	if remaining := headerUint(t, recorder, "RateLimit-Remaining"); remaining != 0 {
+		t.Errorf("Expected RateLimit-Remaining 0, got %d", remaining)
+	}
+	if reset := headerUint(t, recorder, "RateLimit-Reset"); reset != 2 {
+		t.Errorf("Expected RateLimit-Reset 2, got %d", reset)
+	}
+	if retryAfter := headerUint(t, recorder, "Retry-After"); retryAfter != 1 {
+		t.Errorf("Expected Retry-After 1, got %d", retryAfter)
+	}
+}
+
+func TestMiddlewareUnlimited(t *testing.T) {
+	handler := Middleware(NewLimiter(0, 1.0), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", recorder.Code)
+	}
+	if limit := recorder.Header().Get("RateLimit-Limit"); limit != "" {
+		t.Errorf("Unlimited limiter should not set headers, got RateLimit-Limit %q", limit)
+	}
+}
@@ -0,0 +1,119 @@
+package atlimiter
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdempotencyTTL        = time.Minute
+	defaultIdempotencyMaxEntries = 10000
+	// Quantity of independently locked parts of the cache, reduces contention between request IDs
+	idempotencyShards = 16
+)
+
+// - is a bounded cache of recently seen request IDs with their decisions.
+type idempotencyCache struct {
+	seed   maphash.Seed
+	ttl    int64
+	shards [idempotencyShards]idempotencyShard
+}
+
+// - is a part of idempotencyCache guarded by its own mutex.
+type idempotencyShard struct {
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+	// Request IDs in insertion order. TTL is the same for all entries, so it's expiration order too.
+	order []string
+	limit int
+}
+
+// - is a cached decision of request ID.
+type idempotencyEntry struct {
+	allowed bool
+	expires int64
+}
+
+// - is a constructor of idempotencyCache copies.
+func newIdempotencyCache(ttl time.Duration, maxEntries int) *idempotencyCache {
+	c := &idempotencyCache{
+		seed: maphash.MakeSeed(),
+		ttl:  int64(ttl),
+	}
+
+	limit := max(maxEntries/idempotencyShards, 1)
+	for i := range c.shards {
+		c.shards[i].entries = make(map[string]idempotencyEntry)
+		c.shards[i].limit = limit
+	}
+
+	return c
+}
+
+// - returns cached decision of request ID or calls decide and caches its result.
+func (c *idempotencyCache) decide(requestID string, now int64, decide func() bool) bool {
+	shard := &c.shards[maphash.String(c.seed, requestID)%idempotencyShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if entry, ok := shard.entries[requestID]; ok && entry.expires > now {
+		return entry.allowed
+	}
+
+	shard.evict(now)
+
+	allowed := decide()
+	if _, ok := shard.entries[requestID]; !ok {
+		shard.order = append(shard.order, requestID)
+	}
+	shard.entries[requestID] = idempotencyEntry{
+		allowed: allowed,
+		expires: now + c.ttl,
+	}
+
+	return allowed
+}
+
+// - removes expired entries and the oldest ones until there is place for a new entry.
+func (s *idempotencyShard) evict(now int64) {
+	evicted := 0
+	for evicted < len(s.order) {
+		requestID := s.order[evicted]
+		entry := s.entries[requestID]
+		if entry.expires > now && len(s.entries) < s.limit {
+			break
+		}
+		delete(s.entries, requestID)
+		evicted++
+	}
+
+	if evicted > 0 {
+		s.order = append(s.order[:0], s.order[evicted:]...)
+	}
+}
+
+// - allows request only the first time its ID is seen within the idempotency window.
+//
+// Retries of the same request ID get cached decision and don't consume tokens again.
+// Window and size of the cache are set by WithIdempotency.
+// Empty request ID bypasses the cache and behaves like Allow.
+func (r *ATLimiter) AllowOnce(requestID string) bool {
+	if requestID == "" {
+		return r.Allow()
+	}
+
+	r.idempotencyOnce.Do(func() {
+		ttl, maxEntries := r.idempotencyTTL, r.idempotencyMaxEntries
+		if ttl <= 0 {
+			ttl = defaultIdempotencyTTL
+		}
+		if maxEntries <= 0 {
+			maxEntries = defaultIdempotencyMaxEntries
+		}
+		r.idempotency = newIdempotencyCache(ttl, maxEntries)
+	})
+
+	return r.idempotency.decide(requestID, r.nanotime(), r.Allow)
+}
@@ -0,0 +1,72 @@
+package atlimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowOnce(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	for range 5 {
+		if !limiter.AllowOnce("request-1") {
+			t.Error("Replayed request should get cached allowed decision")
+		}
+	}
+	if available := limiter.Available(); available != 9 {
+		t.Errorf("Replays should consume only one token, expected 9 available, got %d", available)
+	}
+
+	if !limiter.AllowOnce("request-2") {
+		t.Error("Different request ID should be allowed")
+	}
+	if available := limiter.Available(); available != 8 {
+		t.Errorf("Expected 8 available, got %d", available)
+	}
+
+	limiter.AllowOnce("")
+	limiter.AllowOnce("")
+	if available := limiter.Available(); available != 6 {
+		t.Errorf("Empty request ID should bypass cache, expected 6 available, got %d", available)
+	}
+}
+
+func TestAllowOnceCachesDenial(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+	limiter.TryAllow(10)
+
+	if limiter.AllowOnce("request") {
+		t.Error("Request should be denied with empty bucket")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if limiter.AllowOnce("request") {
+		t.Error("Replayed request should get cached denied decision")
+	}
+}
+
+func TestAllowOnceExpiration(t *testing.T) {
+	limiter := NewLimiter(10, 1.0, WithIdempotency(50*time.Millisecond, 2))
+
+	limiter.AllowOnce("request-1")
+	time.Sleep(60 * time.Millisecond)
+	limiter.AllowOnce("request-1")
+
+	if available := limiter.Available(); available != 8 {
+		t.Errorf("Request ID should be forgotten after TTL, expected 8 available, got %d", available)
+	}
+}
+
+func TestIdempotencyCacheBounded(t *testing.T) {
+	cache := newIdempotencyCache(time.Minute, idempotencyShards)
+
+	for i := range 100 {
+		cache.decide(string(rune('a'+i)), 0, func() bool { return true })
+	}
+
+	for i := range cache.shards {
+		if entries := len(cache.shards[i].entries); entries > 1 {
+			t.Errorf("Shard %d should hold at most 1 entry, got %d", i, entries)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package atlimiter
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ Limiter = (*ATLimiter)(nil)
+	_ Limiter = (*LeakyBucketLimiter)(nil)
+)
+
+// - is a limiter with leaky bucket discipline: admitted requests leave the modeled queue at exactly maxRPS.
+//
+// Unlike ATLimiter it doesn't tolerate bursts, a request is allowed only if the queue stays within queueLen
+// waiting requests after admitting it. It's a GCRA: the only state is theoretical time when the queue drains,
+// updated by compare and swap.
+type LeakyBucketLimiter struct {
+	// Max quantity of requests per second, the constant outflow rate
+	maxRPS uint64
+	// Max quantity of requests waiting in the modeled queue besides the one being served
+	queueLen uint64
+	// Creation time of limiter, base of monotonic clock
+	epoch time.Time
+	// Time when the modeled queue drains, in nanoseconds since epoch
+	drainAt atomic.Int64
+}
+
+// - is a constructor of leaky bucket limiters.
+//
+// Takes maxRPS, the constant outflow rate, as a parameter. Zero maxRPS means no limit.
+// Time is measured in nanoseconds, so rates over a billion requests per second aren't limited.
+// Takes queueLen, max quantity of requests waiting in the modeled queue, as a parameter.
+// With zero queueLen requests are allowed at most once per 1/maxRPS seconds.
+func NewLeakyBucketLimiter(maxRPS, queueLen uint64) *LeakyBucketLimiter {
+	return &LeakyBucketLimiter{
+		maxRPS:   maxRPS,
+		queueLen: queueLen,
+		epoch:    time.Now(),
+	}
+}
+
+// - checks and allows one request.
+func (l *LeakyBucketLimiter) Allow() bool {
+	return l.TryAllow(1)
+}
+
+// - checks and allows N = tokensCount of requests.
+//
+// Requests are admitted if the queue stays within queueLen after adding them, otherwise the queue is unchanged.
+func (l *LeakyBucketLimiter) TryAllow(tokensCount uint64) bool {
+	if l.maxRPS == 0 || tokensCount == 0 {
+		return true
+	}
+
+	// Max time the queue may take to drain, including the request being served
+	limit := l.serviceTime(saturatingAdd(l.queueLen, 1))
+	cost := l.serviceTime(tokensCount)
+	if cost > limit {
+		return false
+	}
+
+	now := int64(time.Since(l.epoch))
+	for {
+		drainAt := l.drainAt.Load()
+		start := max(drainAt, now)
+		if start-now > limit-cost {
+			return false
+		}
+		if l.drainAt.CompareAndSwap(drainAt, start+cost) {
+			return true
+		}
+	}
+}
+
+// - is a private method of LeakyBucketLimiter that returns nanoseconds of serving N = count of requests at maxRPS.
+//
+// Result saturates at math.MaxInt64.
+func (l *LeakyBucketLimiter) serviceTime(count uint64) int64 {
+	hi, lo := bits.Mul64(count, uint64(time.Second))
+	if hi >= l.maxRPS {
+		return math.MaxInt64
+	}
+	nanoseconds, _ := bits.Div64(hi, lo, l.maxRPS)
+	return int64(min(nanoseconds, math.MaxInt64))
+}
+
+// - returns max quantity of requests per second.
+func (l *LeakyBucketLimiter) GetMaxRPS() uint64 {
+	return l.maxRPS
+}
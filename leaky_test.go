@@ -0,0 +1,42 @@
+package atlimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLeakyBucketLimiter(t *testing.T) {
+	leaky := NewLeakyBucketLimiter(10, 4)
+	bucket := NewLimiter(10, 1.0)
+
+	if allowed := countAllowed(leaky, 20); allowed != 5 {
+		t.Errorf("Leaky bucket should admit 1 served and 4 queued requests of burst, got %d", allowed)
+	}
+	if allowed := countAllowed(bucket, 20); allowed != 10 {
+		t.Errorf("Token bucket of equal rate should admit burst of 10, got %d", allowed)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	if allowed := countAllowed(leaky, 20); allowed != 1 {
+		t.Errorf("Queue should drain one request per 100ms, got %d admitted", allowed)
+	}
+
+	if NewLeakyBucketLimiter(10, 4).TryAllow(6) {
+		t.Error("Should deny batch over queue bound")
+	}
+	if !NewLeakyBucketLimiter(0, 0).TryAllow(math.MaxUint64) {
+		t.Error("Zero maxRPS should mean no limit")
+	}
+}
+
+func TestLeakyBucketServiceTime(t *testing.T) {
+	leaky := NewLeakyBucketLimiter(3, 0)
+
+	if nanoseconds := leaky.serviceTime(1); nanoseconds != 333333333 {
+		t.Errorf("Expected 333333333ns per request, got %d", nanoseconds)
+	}
+	if nanoseconds := leaky.serviceTime(math.MaxUint64); nanoseconds != math.MaxInt64 {
+		t.Errorf("Expected saturated service time, got %d", nanoseconds)
+	}
+}
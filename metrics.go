@@ -0,0 +1,181 @@
+package atlimiter
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// - is returned by RegisterMetrics when limiter with the same name is already registered.
+	ErrMetricsExists = errors.New("atlimiter: metrics with this name are already registered")
+	// - is returned by RegisterMetrics when name is empty or contains '/' or ':'.
+	ErrMetricsName = errors.New("atlimiter: metrics name must be non-empty and contain no '/' or ':'")
+)
+
+// - is a kind of metric value, like metrics.ValueKind of runtime/metrics.
+type MetricKind int
+
+const (
+	// MetricKindBad marks a sample whose name isn't registered.
+	MetricKindBad MetricKind = iota
+	// MetricKindUint64 marks a sample with value in Value field.
+	MetricKindUint64
+)
+
+// - describes a registered metric, like metrics.Description of runtime/metrics.
+type MetricDesc struct {
+	// Full name in form /atlimiter/<limiter name>/<metric>:<unit>
+	Name string
+	// Human-readable description
+	Description string
+	// Kind of metric value
+	Kind MetricKind
+	// Cumulative metrics only grow, the rest are point-in-time values
+	Cumulative bool
+}
+
+// - is a name and value of a metric, like metrics.Sample of runtime/metrics.
+type MetricSample struct {
+	// Name of metric to read, set by caller
+	Name string
+	// Kind of read value, MetricKindBad if name isn't registered
+	Kind MetricKind
+	// Read value
+	Value uint64
+}
+
+// - is a private type of metric provided by every registered limiter.
+type limiterMetric struct {
+	suffix      string
+	description string
+	cumulative  bool
+	read        func(r *ATLimiter) uint64
+}
+
+// Metrics registered for every limiter, ordered by suffix.
+var limiterMetrics = []limiterMetric{
+	{"hooks/panics:panics", "Panics recovered in user-supplied hooks.", true,
+		func(r *ATLimiter) uint64 { return r.hookPanics.Load() }},
+	{"rate/max:requests-per-second", "Current maxRPS.", false,
+		func(r *ATLimiter) uint64 { return atomic.LoadUint64(&r.maxRPS) }},
+	{"refills/performed:refills", "Refills that generated tokens.", true,
+		func(r *ATLimiter) uint64 { return r.refillsPerformed.Load() }},
+	{"refills/skipped:refills", "Refills skipped because another goroutine refilled first.", true,
+		func(r *ATLimiter) uint64 { return r.refillsSkipped.Load() }},
+	{"requests/allowed:requests", "Allowed requests.", true,
+		func(r *ATLimiter) uint64 { return r.allowed.Load() }},
+	{"requests/denied:requests", "Denied requests.", true,
+		func(r *ATLimiter) uint64 { return r.denied.Load() }},
+	{"tokens/available:tokens", "Available tokens.", false,
+		func(r *ATLimiter) uint64 { return r.Available() }},
+	{"tokens/capacity:tokens", "Max quantity of tokens.", false,
+		func(r *ATLimiter) uint64 { return atomic.LoadUint64(&r.capacity) }},
+}
+
+// - is a private type of registered metric bound to its limiter.
+type registeredMetric struct {
+	limiter *ATLimiter
+	read    func(r *ATLimiter) uint64
+}
+
+// Registry of metrics of all registered limiters, descriptions are kept sorted by name.
+var metricsRegistry struct {
+	mu           sync.RWMutex
+	limiters     map[string]bool
+	metrics      map[string]registeredMetric
+	descriptions []MetricDesc
+}
+
+// - registers limiter's counters under the given name for Descriptions and ReadMetrics.
+//
+// Metrics are named /atlimiter/<name>/<metric>:<unit>, e.g. /atlimiter/api/requests/denied:requests.
+// If name is already taken returns ErrMetricsExists, if it's malformed returns ErrMetricsName.
+// Registration lasts until UnregisterMetrics is called with the name, the registry keeps limiter reachable
+// until then, so limiters with shorter lifetime than the process must be unregistered.
+func (r *ATLimiter) RegisterMetrics(name string) error {
+	if name == "" || strings.ContainsAny(name, "/:") {
+		return ErrMetricsName
+	}
+
+	metricsRegistry.mu.Lock()
+	defer metricsRegistry.mu.Unlock()
+
+	if metricsRegistry.limiters[name] {
+		return ErrMetricsExists
+	}
+	if metricsRegistry.limiters == nil {
+		metricsRegistry.limiters = map[string]bool{}
+		metricsRegistry.metrics = map[string]registeredMetric{}
+	}
+	metricsRegistry.limiters[name] = true
+
+	for _, metric := range limiterMetrics {
+		fullName := "/atlimiter/" + name + "/" + metric.suffix
+		metricsRegistry.metrics[fullName] = registeredMetric{limiter: r, read: metric.read}
+		metricsRegistry.descriptions = append(metricsRegistry.descriptions, MetricDesc{
+			Name:        fullName,
+			Description: metric.description,
+			Kind:        MetricKindUint64,
+			Cumulative:  metric.cumulative,
+		})
+	}
+	slices.SortFunc(metricsRegistry.descriptions, func(a, b MetricDesc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return nil
+}
+
+// - removes metrics of limiter registered under the given name and reports if they were registered.
+//
+// Name becomes free for RegisterMetrics again and the registry drops its reference to the limiter.
+func UnregisterMetrics(name string) bool {
+	metricsRegistry.mu.Lock()
+	defer metricsRegistry.mu.Unlock()
+
+	if !metricsRegistry.limiters[name] {
+		return false
+	}
+	delete(metricsRegistry.limiters, name)
+
+	prefix := "/atlimiter/" + name + "/"
+	for _, metric := range limiterMetrics {
+		delete(metricsRegistry.metrics, prefix+metric.suffix)
+	}
+	metricsRegistry.descriptions = slices.DeleteFunc(metricsRegistry.descriptions, func(desc MetricDesc) bool {
+		return strings.HasPrefix(desc.Name, prefix)
+	})
+
+	return true
+}
+
+// - returns descriptions of metrics of all registered limiters sorted by name, like metrics.All of runtime/metrics.
+func Descriptions() []MetricDesc {
+	metricsRegistry.mu.RLock()
+	defer metricsRegistry.mu.RUnlock()
+
+	return slices.Clone(metricsRegistry.descriptions)
+}
+
+// - fills in values of samples by their names, like metrics.Read of runtime/metrics.
+//
+// Samples slice can be reused between calls, reading doesn't allocate.
+// Samples with unknown names get MetricKindBad and zero value.
+func ReadMetrics(samples []MetricSample) {
+	metricsRegistry.mu.RLock()
+	defer metricsRegistry.mu.RUnlock()
+
+	for i := range samples {
+		metric, ok := metricsRegistry.metrics[samples[i].Name]
+		if !ok {
+			samples[i].Kind = MetricKindBad
+			samples[i].Value = 0
+			continue
+		}
+		samples[i].Kind = MetricKindUint64
+		samples[i].Value = metric.read(metric.limiter)
+	}
+}
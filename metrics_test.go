@@ -0,0 +1,86 @@
+package atlimiter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadMetrics(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	name := uniqueName("metrics_test")
+	if err := limiter.RegisterMetrics(name); err != nil {
+		t.Fatalf("RegisterMetrics returned error: %v", err)
+	}
+	t.Cleanup(func() { UnregisterMetrics(name) })
+
+	limiter.TryAllow(10)
+	limiter.Allow()
+
+	prefix := "/atlimiter/" + name + "/"
+	expected := map[string]uint64{
+		prefix + "requests/allowed:requests":    1,
+		prefix + "requests/denied:requests":     1,
+		prefix + "tokens/available:tokens":      0,
+		prefix + "tokens/capacity:tokens":       10,
+		prefix + "rate/max:requests-per-second": 10,
+	}
+
+	var samples []MetricSample
+	for _, desc := range Descriptions() {
+		if _, ok := expected[desc.Name]; ok {
+			samples = append(samples, MetricSample{Name: desc.Name})
+		}
+	}
+	if len(samples) != len(expected) {
+		t.Fatalf("Expected %d described metrics, got %d", len(expected), len(samples))
+	}
+	samples = append(samples, MetricSample{Name: "/atlimiter/unknown/requests/allowed:requests", Value: 42})
+
+	ReadMetrics(samples)
+	for _, sample := range samples[:len(samples)-1] {
+		if sample.Kind != MetricKindUint64 || sample.Value != expected[sample.Name] {
+			t.Errorf("Expected %s %d, got %d of kind %d", sample.Name, expected[sample.Name], sample.Value, sample.Kind)
+		}
+	}
+	if unknown := samples[len(samples)-1]; unknown.Kind != MetricKindBad || unknown.Value != 0 {
+		t.Errorf("Expected unknown metric to be bad and zero, got %d of kind %d", unknown.Value, unknown.Kind)
+	}
+
+	if err := NewLimiter(1, 1.0).RegisterMetrics(name); !errors.Is(err, ErrMetricsExists) {
+		t.Errorf("Expected ErrMetricsExists on duplicate name, got %v", err)
+	}
+	if err := NewLimiter(1, 1.0).RegisterMetrics("a/b"); !errors.Is(err, ErrMetricsName) {
+		t.Errorf("Expected ErrMetricsName on malformed name, got %v", err)
+	}
+}
+
+func TestUnregisterMetrics(t *testing.T) {
+	name := uniqueName("metrics_test")
+	if err := NewLimiter(10, 1.0).RegisterMetrics(name); err != nil {
+		t.Fatalf("RegisterMetrics returned error: %v", err)
+	}
+
+	if !UnregisterMetrics(name) {
+		t.Fatal("Registered name should be unregistered")
+	}
+	if UnregisterMetrics(name) {
+		t.Error("Repeated unregistration should report nothing removed")
+	}
+
+	samples := []MetricSample{{Name: "/atlimiter/" + name + "/requests/allowed:requests"}}
+	ReadMetrics(samples)
+	if samples[0].Kind != MetricKindBad {
+		t.Error("Unregistered metrics should not be readable")
+	}
+	for _, desc := range Descriptions() {
+		if desc.Name == samples[0].Name {
+			t.Error("Unregistered metrics should not be described")
+		}
+	}
+
+	if err := NewLimiter(10, 1.0).RegisterMetrics(name); err != nil {
+		t.Errorf("Name should be free after unregistration, got %v", err)
+	}
+	UnregisterMetrics(name)
+}
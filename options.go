@@ -0,0 +1,286 @@
+package atlimiter
+
+import (
+	"log/slog"
+	"time"
+)
+
+// - is an optional setting of ATLimiter applied by NewLimiter.
+type Option func(*ATLimiter)
+
+// - sets window and size of the request IDs cache used by AllowOnce.
+//
+// Takes ttl, the time during which repeated request ID gets cached decision, as a parameter.
+// Takes maxEntries, the max quantity of remembered request IDs, as a parameter.
+// Non-positive values keep defaults (one minute and 10000 entries).
+func WithIdempotency(ttl time.Duration, maxEntries int) Option {
+	return func(r *ATLimiter) {
+		if ttl > 0 {
+			r.idempotencyTTL = ttl
+		}
+		if maxEntries > 0 {
+			r.idempotencyMaxEntries = maxEntries
+		}
+	}
+}
+
+// - sets storage of tokens and last refill time instead of in-struct atomics.
+//
+// Store is used as is: NewLimiter doesn't fill it, so state shared with other processes isn't reset.
+// New store should be initialized by its owner with tokens and current unix nanoseconds.
+func WithTokenStore(store TokenStore) Option {
+	return func(r *ATLimiter) {
+		r.store = store
+	}
+}
+
+// - sets soft ceiling for ordinary requests below capacity.
+//
+// Allow, TryAllow and Wait can take only n tokens from full container, i.e. they are denied once
+// available drops to capacity-n. The top slice of capacity stays reserved for AllowPriority.
+// Zero or n not less than capacity disables soft limit.
+func WithSoftLimit(n uint64) Option {
+	return func(r *ATLimiter) {
+		r.softLimit = n
+	}
+}
+
+// - enables structured summary of limiter's traffic logged at INFO level every interval.
+//
+// Summary contains allowed and denied requests during interval, current rate of allowed requests per second
+// and utilization, the ratio of tokens granted per second to maxRPS. Intervals without traffic aren't logged.
+// Logging goroutine is launched by Start and stopped by Close.
+func WithPeriodicLog(interval time.Duration, logger *slog.Logger) Option {
+	return func(r *ATLimiter) {
+		r.logInterval = interval
+		r.logger = logger
+	}
+}
+
+// - sets quantity of tokens the limiter starts with instead of full container.
+//
+// Zero disables the startup burst. Value is clamped to capacity.
+// It overrides process-wide default set by SetDefaultInitialTokens.
+func WithInitialTokens(n uint64) Option {
+	return func(r *ATLimiter) {
+		r.initialTokens = n
+		r.hasInitialTokens = true
+	}
+}
+
+// - sets source of current time instead of time.Now, e.g. fake clock in tests.
+func WithClock(now func() time.Time) Option {
+	return func(r *ATLimiter) {
+		r.clock = now
+	}
+}
+
+// - sets source of timers instead of time.After, used together with WithClock.
+//
+// after must return channel that receives current time once the clock set by WithClock advances by d,
+// so Wait, background features and Ticker follow the same clock as the limiter, e.g. fake clock in tests.
+func WithTimer(after func(d time.Duration) <-chan time.Time) Option {
+	return func(r *ATLimiter) {
+		r.timer = after
+	}
+}
+
+// - enables smoothing mode where capacity is always one token.
+//
+// Requests are spaced evenly at 1/maxRPS intervals without any burst, capacityFactor is ignored.
+func WithSmoothing() Option {
+	return func(r *ATLimiter) {
+		r.smoothing = true
+	}
+}
+
+// - enables fair waiting mode where Wait and WaitN serve waiters in FIFO order.
+//
+// Waiters are queued and only the head of the queue sleeps until tokens refill,
+// which guarantees arrival order and prevents wakeup storms of many waiters racing on CAS.
+func WithFairWaiting() Option {
+	return func(r *ATLimiter) {
+		r.fairWaiting = true
+	}
+}
+
+// - sets max quantity of requests AllowQueued keeps waiting for tokens.
+//
+// Requests over maxLen are denied immediately. Non-positive maxLen disables queueing.
+func WithQueue(maxLen int) Option {
+	return func(r *ATLimiter) {
+		r.queueMaxLen = maxLen
+	}
+}
+
+// - makes limiter measure time by wall clock.
+//
+// Wall time is persistence-friendly: Snapshot taken in one process can be restored in another,
+// and the limiter refills for real time elapsed in between. But it follows system clock jumps.
+func WithWallClock() Option {
+	return func(r *ATLimiter) {
+		r.wallClock = true
+	}
+}
+
+// - makes limiter measure time by monotonic clock, which is the default.
+//
+// Monotonic time ignores system clock jumps, but its readings aren't meaningful
+// outside of the process, so Snapshot and Restore can't be used across restarts.
+func WithMonotonicClock() Option {
+	return func(r *ATLimiter) {
+		r.wallClock = false
+	}
+}
+
+// - ties maxRPS to external signal sampled every interval.
+//
+// fn returns desired maxRPS (e.g. derived from queue depth or memory usage), and the limiter applies it
+// via SetMaxRPS keeping current capacity factor. Unchanged values are skipped.
+// Sampling goroutine is launched by Start and stopped by Close.
+func WithDynamicRate(interval time.Duration, fn func() uint64) Option {
+	return func(r *ATLimiter) {
+		r.rateInterval = interval
+		r.rateFn = fn
+	}
+}
+
+// - caps quantity of tokens added by one refill.
+//
+// After a pause (e.g. GC stall) a single refill would add all tokens generated during it at once.
+// With the cap recovery is spread over several refills: the rest of elapsed time is carried forward,
+// not lost, until the container is full.
+func WithMaxRefillStep(n uint64) Option {
+	return func(r *ATLimiter) {
+		r.maxRefillStep = n
+	}
+}
+
+// - enables sampling of denied requests into ring buffer of given size, read by RecentDenials.
+//
+// It gives a quick view of what got denied recently without full tracing.
+// Buffer is locked only on the deny path. Non-positive size disables sampling.
+func WithDenialSampling(size int) Option {
+	return func(r *ATLimiter) {
+		if size > 0 {
+			r.denials = newDenialRing(size)
+		}
+	}
+}
+
+// - makes panics of user-supplied hooks propagate instead of being recovered.
+//
+// By default hook panics are logged and counted in Stats().HookPanics.
+func WithStrictHooks() Option {
+	return func(r *ATLimiter) {
+		r.strictHooks = true
+	}
+}
+
+// - sets soft rate that fires a warning hook but still allows requests.
+//
+// When tokens granted during current second exceed softRPS, onSoftExceed is called.
+// Hook is debounced: it fires at most once per second, not per request.
+// It gives advance notice before hitting maxRPS, e.g. for proactive scaling.
+// Hook is called synchronously in the goroutine whose request crossed the threshold, its panics are recovered.
+func WithSoftRate(softRPS uint64, onSoftExceed func()) Option {
+	return func(r *ATLimiter) {
+		r.softRPS = softRPS
+		r.onSoftExceed = onSoftExceed
+		r.trackGrants.Store(true)
+	}
+}
+
+// - enables counting of tokens granted per second from creation of limiter.
+//
+// Counting is used by AllowWithinFraction, ObservedRate and CanSustain. It's off by default
+// and starts on the first call of those methods, so without this option their first second is undercounted.
+func WithGrantTracking() Option {
+	return func(r *ATLimiter) {
+		r.trackGrants.Store(true)
+	}
+}
+
+// - sets rounding of fractional capacity calculated as maxRPS * capacityFactor.
+//
+// By default capacity is truncated (CapacityTruncate), so maxRPS=3 and factor=1.5 give 4.
+// CapacityRound gives 5 and CapacityCeil rounds any fraction up. Mode is used by SetMaxRPS too.
+func WithCapacityRounding(mode CapacityRounding) Option {
+	return func(r *ATLimiter) {
+		r.rounding = mode
+	}
+}
+
+// - enables auto-tuning of capacity by observed fraction of denied requests.
+//
+// Every second capacity grows by a quarter if more than targetDenyRate of requests were denied, as bursts
+// are being rejected, and shrinks by a tenth if less than half of targetDenyRate were denied, as capacity is wasted.
+// Each step changes capacity by at least one token. Capacity is moved only towards minCapacity and maxCapacity,
+// seconds without traffic are skipped. Capacity is never tuned below maxRPS, so lower minCapacity is raised to it.
+// SetMaxRPS overrides learned capacity. It has no effect in smoothing mode.
+// Option is ignored unless targetDenyRate is between 0 and 1 and 0 < minCapacity <= maxCapacity.
+// Tuning goroutine is launched by Start and stopped by Close.
+func WithAutoCapacity(targetDenyRate float64, minCapacity, maxCapacity uint64) Option {
+	return func(r *ATLimiter) {
+		if targetDenyRate <= 0 || targetDenyRate >= 1 || minCapacity == 0 || minCapacity > maxCapacity {
+			return
+		}
+		r.autoCapacity = true
+		r.targetDenyRate = targetDenyRate
+		r.minCapacity = max(minCapacity, r.maxRPS)
+		r.maxCapacity = max(maxCapacity, r.minCapacity)
+	}
+}
+
+// - replaces continuous refill with fixed windows: tokens snap back to capacity at each interval boundary.
+//
+// Boundaries are aligned to wall clock by truncating time to interval, so it models fixed-window quotas
+// like "1000 requests per calendar minute": NewLimiterCap(1, 1000, WithWindowReset(time.Minute)).
+// maxRPS only tells limited limiter from unlimited one. TimeUntilAvailable returns time until the next boundary.
+func WithWindowReset(interval time.Duration) Option {
+	return func(r *ATLimiter) {
+		r.windowReset = interval
+	}
+}
+
+// - sets gate consulted before tokens by every method that takes tokens.
+//
+// If fn returns false the request is denied without consuming tokens, otherwise usual token logic applies.
+// Wait and WaitN return ErrGateClosed instead of waiting for the gate to open.
+// It's designed for external policy, e.g. denying writes during read-only maintenance window. fn is called
+// on every request, so it must be cheap. Panic in fn is recovered and closes the gate for that request.
+func WithGate(fn func() bool) Option {
+	return func(r *ATLimiter) {
+		r.gate = fn
+	}
+}
+
+// - sets hook called when tokens transition from available to zero, i.e. the limiter starts shedding.
+//
+// Hook is edge-triggered: it fires once per transition, not per denied request.
+// It's called synchronously in the goroutine that took the last token, its panics are recovered.
+func WithOnEmpty(fn func()) Option {
+	return func(r *ATLimiter) {
+		r.onEmpty = fn
+	}
+}
+
+// - sets hook called when tokens transition from zero to available, i.e. the limiter stops shedding.
+//
+// Hook is edge-triggered like WithOnEmpty. It's called synchronously in the goroutine whose refill
+// added tokens, its panics are recovered.
+func WithOnRecovered(fn func()) Option {
+	return func(r *ATLimiter) {
+		r.onRecovered = fn
+	}
+}
+
+// - sets policy of deciding on requests when tokens run out, OverflowStrict by default.
+//
+// With OverflowGrace limiters sharing an upstream can grant one borderline request at zero tokens
+// and pay it back from the next refill.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(r *ATLimiter) {
+		r.overflowPolicy = policy
+	}
+}
@@ -0,0 +1,85 @@
+package atlimiter
+
+import (
+	"math"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// - is a constructor of limiter without burst: capacity equals maxRPS (factor 1.0).
+func NewStrictLimiter(maxRPS uint64, opts ...Option) *ATLimiter {
+	return NewLimiter(maxRPS, 1.0, opts...)
+}
+
+// - is a constructor of limiter that allows burst of two seconds worth of requests (factor 2.0).
+func NewBurstyLimiter(maxRPS uint64, opts ...Option) *ATLimiter {
+	return NewLimiter(maxRPS, 2.0, opts...)
+}
+
+// - is a constructor of limiter in smoothing mode: capacity is one token, so requests are spaced evenly at 1/maxRPS.
+func NewSmoothLimiter(maxRPS uint64, opts ...Option) *ATLimiter {
+	return NewLimiter(maxRPS, 1.0, slices.Concat(opts, []Option{WithSmoothing()})...)
+}
+
+// - returns capacityFactor that makes container hold burst duration worth of tokens.
+//
+// E.g. maxRPS=100 and burst=3s give factor 3.0, i.e. capacity 300.
+// Like NewLimiter the factor is never less than 1.0, unlimited limiter (maxRPS equals zero) gets 1.0.
+func FactorForBurst(maxRPS uint64, burst time.Duration) float64 {
+	if maxRPS == 0 {
+		return 1.0
+	}
+	return max(burst.Seconds(), 1.0)
+}
+
+// - suggests maxRPS that allows targetAllowFraction of requests arriving at arrivalRate per second.
+//
+// It's a heuristic starting point for capacity planning: arrivalRate * targetAllowFraction rounded up.
+// Random (e.g. Poisson) arrivals come in bursts, so the real allowed fraction is lower unless capacity
+// absorbs them, see FactorForBurst. Fraction is clamped to [0, 1]. Result is at least 1,
+// because zero maxRPS means no limit.
+func SuggestMaxRPS(arrivalRate, targetAllowFraction float64) uint64 {
+	fraction := min(max(targetAllowFraction, 0), 1)
+	return max(floatToUint64(math.Ceil(arrivalRate*fraction)), 1)
+}
+
+// - returns share of total maxRPS for replica with the given index out of replicas, for use with NewLimiter.
+//
+// Remainder is given to the first replicas one by one, so shares sum exactly to total (e.g. 100 over 3 replicas
+// gives 34, 33 and 33). It's a static split that doesn't adapt to uneven load of replicas.
+// Returns zero if replicas isn't positive or index is out of range. Zero share also happens if total
+// is less than replicas. NewLimiter treats zero maxRPS as no limit, so such replicas must not build a limiter from it.
+func SplitLimit(total uint64, replicas, index int) uint64 {
+	if replicas <= 0 || index < 0 || index >= replicas {
+		return 0
+	}
+
+	share := total / uint64(replicas)
+	if uint64(index) < total%uint64(replicas) {
+		share++
+	}
+	return share
+}
+
+// - is a constructor of limiter whose maxRPS scales with CPU: maxRPS = rpsPerCore * GOMAXPROCS.
+//
+// GOMAXPROCS is read once at construction. If it changes at runtime, maxRPS stays the same
+// unless the limiter is created with WithDynamicRate(interval, PerCoreRate(rpsPerCore)) and started,
+// then it re-reads GOMAXPROCS every interval.
+func NewPerCoreLimiter(rpsPerCore uint64, capacityFactor float64, opts ...Option) *ATLimiter {
+	return NewLimiter(PerCoreRate(rpsPerCore)(), capacityFactor, opts...)
+}
+
+// - returns function that calculates maxRPS as rpsPerCore * current GOMAXPROCS, saturating at math.MaxUint64.
+//
+// It's designed as signal for WithDynamicRate to follow GOMAXPROCS changes.
+func PerCoreRate(rpsPerCore uint64) func() uint64 {
+	return func() uint64 {
+		cores := uint64(runtime.GOMAXPROCS(0))
+		if rpsPerCore > math.MaxUint64/cores {
+			return math.MaxUint64
+		}
+		return rpsPerCore * cores
+	}
+}
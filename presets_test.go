@@ -0,0 +1,154 @@
+package atlimiter
+
+import (
+	"math"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func countAllowed(limiter Limiter, attempts int) int {
+	allowed := 0
+	for range attempts {
+		if limiter.Allow() {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestNewStrictLimiter(t *testing.T) {
+	limiter := NewStrictLimiter(10, WithClock(newFakeClock().Now))
+
+	if allowed := countAllowed(limiter, 30); allowed != 10 {
+		t.Errorf("Strict limiter should allow no burst beyond maxRPS, expected 10, got %d", allowed)
+	}
+}
+
+func TestNewBurstyLimiter(t *testing.T) {
+	limiter := NewBurstyLimiter(10, WithClock(newFakeClock().Now))
+
+	if allowed := countAllowed(limiter, 30); allowed != 20 {
+		t.Errorf("Bursty limiter should allow 2x burst, expected 20, got %d", allowed)
+	}
+}
+
+func TestNewSmoothLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSmoothLimiter(10, WithClock(clock.Now))
+
+	if capacity := limiter.GetCapacity(); capacity != 1 {
+		t.Fatalf("Expected capacity 1 in smoothing mode, got %d", capacity)
+	}
+
+	for i := range 5 {
+		if allowed := countAllowed(limiter, 10); allowed != 1 {
+			t.Errorf("Interval %d: smooth limiter should allow exactly one request, got %d", i, allowed)
+		}
+		clock.Advance(99 * time.Millisecond)
+		if limiter.Allow() {
+			t.Errorf("Interval %d: request before 1/maxRPS should be denied", i)
+		}
+		clock.Advance(time.Millisecond)
+	}
+
+	limiter.SetMaxRPS(20, 3.0)
+	if capacity := limiter.GetCapacity(); capacity != 1 {
+		t.Errorf("Smoothing mode should keep capacity 1 after SetMaxRPS, got %d", capacity)
+	}
+
+	opts := make([]Option, 1, 2)
+	opts[0] = WithClock(clock.Now)
+	NewSmoothLimiter(10, opts...)
+	if extra := opts[:2][1]; extra != nil {
+		t.Error("Caller's options should not be modified")
+	}
+}
+
+func TestFactorForBurst(t *testing.T) {
+	factor := FactorForBurst(100, 3*time.Second)
+	if factor != 3.0 {
+		t.Errorf("Expected factor 3.0, got %v", factor)
+	}
+	if capacity := NewLimiter(100, factor).GetCapacity(); capacity != 300 {
+		t.Errorf("Expected capacity 300, got %d", capacity)
+	}
+
+	if capacity := NewLimiter(100, FactorForBurst(100, 2500*time.Millisecond)).GetCapacity(); capacity != 250 {
+		t.Errorf("Expected capacity 250, got %d", capacity)
+	}
+	if factor := FactorForBurst(100, 100*time.Millisecond); factor != 1.0 {
+		t.Errorf("Factor should be clamped to 1.0, got %v", factor)
+	}
+}
+
+func TestSuggestMaxRPS(t *testing.T) {
+	if maxRPS := SuggestMaxRPS(100, 0.905); maxRPS != 91 {
+		t.Errorf("Expected 91 RPS rounded up, got %d", maxRPS)
+	}
+
+	previous := uint64(0)
+	for _, fraction := range []float64{0.1, 0.25, 0.5, 0.75, 0.9, 1.0} {
+		maxRPS := SuggestMaxRPS(1000, fraction)
+		if maxRPS <= previous {
+			t.Errorf("Higher fraction %v should suggest higher maxRPS, got %d after %d", fraction, maxRPS, previous)
+		}
+		previous = maxRPS
+	}
+
+	if maxRPS := SuggestMaxRPS(1000, 2); maxRPS != 1000 {
+		t.Errorf("Fraction should be clamped to 1, got %d", maxRPS)
+	}
+	if maxRPS := SuggestMaxRPS(1000, 0); maxRPS != 1 {
+		t.Errorf("Suggestion should never mean unlimited, got %d", maxRPS)
+	}
+}
+
+func TestSplitLimit(t *testing.T) {
+	expected := []uint64{34, 33, 33}
+	var sum uint64
+	for index, share := range expected {
+		if got := SplitLimit(100, 3, index); got != share {
+			t.Errorf("Expected share %d for replica %d, got %d", share, index, got)
+		}
+		sum += SplitLimit(100, 3, index)
+	}
+	if sum != 100 {
+		t.Errorf("Shares should sum to total 100, got %d", sum)
+	}
+
+	sum = 0
+	for index := range 7 {
+		sum += SplitLimit(math.MaxUint64, 7, index)
+	}
+	if sum != math.MaxUint64 {
+		t.Errorf("Shares should sum to total without overflow, got %d", sum)
+	}
+
+	if share := SplitLimit(100, 0, 0); share != 0 {
+		t.Errorf("Expected zero share without replicas, got %d", share)
+	}
+	if share := SplitLimit(100, 3, 3); share != 0 {
+		t.Errorf("Expected zero share for index out of range, got %d", share)
+	}
+}
+
+func TestNewPerCoreLimiter(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	limiter := NewPerCoreLimiter(100, 1.5)
+	if maxRPS := limiter.GetMaxRPS(); maxRPS != 400 {
+		t.Errorf("Expected maxRPS 400 for 4 cores, got %d", maxRPS)
+	}
+	if capacity := limiter.GetCapacity(); capacity != 600 {
+		t.Errorf("Expected capacity 600, got %d", capacity)
+	}
+
+	runtime.GOMAXPROCS(2)
+	if rate := PerCoreRate(100)(); rate != 200 {
+		t.Errorf("PerCoreRate should follow GOMAXPROCS, expected 200, got %d", rate)
+	}
+	if rate := PerCoreRate(math.MaxUint64)(); rate != math.MaxUint64 {
+		t.Errorf("PerCoreRate should saturate, got %d", rate)
+	}
+}
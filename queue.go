@@ -0,0 +1,29 @@
+package atlimiter
+
+import "context"
+
+// - allows the request, queueing it if there are no tokens.
+//
+// Queued requests are served in FIFO order as tokens free up, sharing the queue with fair waiting mode.
+// If queue already holds maxLen requests set by WithQueue, the request is denied immediately,
+// so the population of waiting goroutines stays bounded unlike Wait. Without WithQueue it works like Allow.
+// If ctx is done before the request is served it's denied.
+func (r *ATLimiter) AllowQueued(ctx context.Context) bool {
+	if r.queued.Load() == 0 {
+		if now := r.nanotime(); r.take(now, 1) {
+			addConsumed(ctx, 1)
+			return r.record(now, true, 1)
+		}
+	}
+
+	if r.queued.Add(1) > int64(r.queueMaxLen) {
+		r.queued.Add(-1)
+		return r.record(r.nanotime(), false, 1)
+	}
+	defer r.queued.Add(-1)
+
+	if _, err := r.waitFair(ctx, 1); err != nil {
+		return r.record(r.nanotime(), false, 1)
+	}
+	return true
+}
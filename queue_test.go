@@ -0,0 +1,68 @@
+package atlimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAllowQueued(t *testing.T) {
+	limiter := NewLimiter(50, 1.0, WithQueue(3))
+	limiter.TryAllow(50)
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !limiter.AllowQueued(context.Background()) {
+				t.Errorf("Queued request %d should be served", i)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}()
+
+		waitFor(t, func() bool { return queueLen(limiter) == i+1 })
+	}
+
+	if length := limiter.Stats().QueueLen; length != 3 {
+		t.Errorf("Expected QueueLen 3, got %d", length)
+	}
+
+	start := time.Now()
+	if limiter.AllowQueued(context.Background()) {
+		t.Error("Request over full queue should be denied")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("Request over full queue should be denied immediately, took %v", elapsed)
+	}
+
+	wg.Wait()
+	for i, id := range order {
+		if id != i {
+			t.Fatalf("Queued requests should be served in FIFO order, got %v", order)
+		}
+	}
+
+	if stats := limiter.Stats(); stats.QueueLen != 0 || stats.Allowed != 4 || stats.Denied != 1 {
+		t.Errorf("Expected empty queue, 4 allowed and 1 denied, got %d, %d and %d", stats.QueueLen, stats.Allowed, stats.Denied)
+	}
+}
+
+func TestAllowQueuedWithoutQueue(t *testing.T) {
+	limiter := NewLimiter(10, 1.0)
+
+	if !limiter.AllowQueued(context.Background()) {
+		t.Error("Should allow with available tokens")
+	}
+	limiter.TryAllow(9)
+	if limiter.AllowQueued(context.Background()) {
+		t.Error("Without WithQueue should deny like Allow")
+	}
+}
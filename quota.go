@@ -0,0 +1,87 @@
+package atlimiter
+
+import "sync/atomic"
+
+// - is a wrapper over ATLimiter that layers an absolute quota on top of the rate limit.
+//
+// Rate limit restricts speed of requests while quota restricts their total quantity
+// (e.g. 100 requests per second but no more than 1M requests per month).
+type QuotaGuard struct {
+	// Wrapped rate limiter
+	limiter *ATLimiter
+	// Max quantity of requests allowed during lifetime of quota
+	quota uint64
+	// Monotonic counter of requests allowed since creation or last reset
+	used atomic.Uint64
+}
+
+// - is a constructor of QuotaGuard copies.
+//
+// Takes limiter, the rate limiter to wrap, as a parameter.
+// Takes quota, the max total quantity of requests until Reset, as a parameter.
+func NewQuotaGuard(limiter *ATLimiter, quota uint64) *QuotaGuard {
+	return &QuotaGuard{
+		limiter: limiter,
+		quota:   quota,
+	}
+}
+
+// - checks quota and rate limit and allows request if both have place for it.
+//
+// Once quota is exhausted returns false regardless of available rate tokens.
+func (q *QuotaGuard) Allow() bool {
+	return q.TryAllow(1)
+}
+
+// - checks quota and rate limit and allows N = tokensCount of requests.
+//
+// Quota is taken first with compare-and-swap and given back if rate limiter denies request,
+// so denied requests don't spend quota and exhausted quota doesn't spend rate tokens.
+func (q *QuotaGuard) TryAllow(tokensCount uint64) bool {
+	if tokensCount == 0 {
+		return true
+	}
+
+	for {
+		used := q.used.Load()
+		if used > q.quota || q.quota-used < tokensCount {
+			return false
+		}
+		if q.used.CompareAndSwap(used, used+tokensCount) {
+			break
+		}
+	}
+
+	if q.limiter.TryAllow(tokensCount) {
+		return true
+	}
+
+	q.release(tokensCount)
+	return false
+}
+
+// - gives back quota taken by request that was denied by rate limiter.
+//
+// Counter can be reset concurrently so it never goes below zero.
+func (q *QuotaGuard) release(tokensCount uint64) {
+	for {
+		used := q.used.Load()
+		if q.used.CompareAndSwap(used, used-min(used, tokensCount)) {
+			return
+		}
+	}
+}
+
+// - returns quantity of requests left in quota
+func (q *QuotaGuard) Remaining() uint64 {
+	used := q.used.Load()
+	if used >= q.quota {
+		return 0
+	}
+	return q.quota - used
+}
+
+// - resets used quota to zero, e.g. on monthly rollover.
+func (q *QuotaGuard) Reset() {
+	q.used.Store(0)
+}
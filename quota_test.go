@@ -0,0 +1,45 @@
+package atlimiter
+
+import "testing"
+
+func TestQuotaGuard(t *testing.T) {
+	guard := NewQuotaGuard(NewLimiter(100, 1.0), 5)
+
+	for i := range 5 {
+		if !guard.Allow() {
+			t.Errorf("Request %d should be allowed", i)
+		}
+	}
+
+	if guard.Allow() {
+		t.Error("Request 6 should be denied after quota is exhausted")
+	}
+	if available := guard.limiter.Available(); available != 95 {
+		t.Errorf("Expected 95 rate tokens left, got %d", available)
+	}
+	if remaining := guard.Remaining(); remaining != 0 {
+		t.Errorf("Expected 0 remaining quota, got %d", remaining)
+	}
+
+	guard.Reset()
+	if remaining := guard.Remaining(); remaining != 5 {
+		t.Errorf("Expected 5 remaining quota after reset, got %d", remaining)
+	}
+	if !guard.Allow() {
+		t.Error("Request should be allowed after reset")
+	}
+}
+
+func TestQuotaGuardRateDenied(t *testing.T) {
+	guard := NewQuotaGuard(NewLimiter(10, 1.0), 100)
+
+	if !guard.TryAllow(10) {
+		t.Error("Should allow 10 tokens")
+	}
+	if guard.Allow() {
+		t.Error("Should deny when rate tokens are exhausted")
+	}
+	if remaining := guard.Remaining(); remaining != 90 {
+		t.Errorf("Denied request should not spend quota, expected 90 remaining, got %d", remaining)
+	}
+}
@@ -0,0 +1,226 @@
+package atlimiter
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Refill strategies compared by benchmarks below. Each one generates tokens of rate per second
+// for time passed since previous refill, capacity isn't modeled to measure generation alone.
+//
+// Tradeoffs:
+//   - floatNaive moves refill time to now, so fraction of a token generated since the last whole one is lost
+//     on every refill. The more often refill is called, the more tokens are lost.
+//   - floatCarry moves refill time only by the time that generated whole tokens, so fraction is carried forward.
+//     It's one CAS on the refill time like floatNaive. Truncation of spent time to whole nanoseconds
+//     can only keep slightly more time for the next refill, so over long runs it may generate a token
+//     earlier, never lose one. It's the default used by ATLimiter.refillAt, benchmarked as limiter.
+//   - integer is floatCarry in 128-bit integer math without float rounding at huge rates. Spent time is rounded
+//     up so it never generates early, but it lags up to a nanosecond per refill and loses a token over long runs.
+//   - accumulator keeps fractional nano-tokens under a mutex, exact but serializes all callers.
+//   - atomicAdd swaps refill time and adds nano-tokens with atomic add, exact and lock-free,
+//     but consumption and clamping to capacity have to work in nano-tokens too.
+//
+// floatCarry is the default: it loses no tokens in both call patterns and costs about as much as floatNaive,
+// while accumulator is an order slower on frequent calls and atomicAdd would change the whole token model.
+type refillStrategy interface {
+	refill(now int64)
+	load() uint64
+}
+
+type floatNaive struct {
+	rate   uint64
+	last   atomic.Int64
+	tokens atomic.Uint64
+}
+
+func (s *floatNaive) refill(now int64) {
+	last := s.last.Load()
+	elapsed := now - last
+	if elapsed <= 0 {
+		return
+	}
+	newTokens := uint64(float64(s.rate) * float64(elapsed) / 1e9)
+	if newTokens > 0 && s.last.CompareAndSwap(last, now) {
+		s.tokens.Add(newTokens)
+	}
+}
+
+func (s *floatNaive) load() uint64 { return s.tokens.Load() }
+
+type floatCarry struct {
+	rate   uint64
+	last   atomic.Int64
+	tokens atomic.Uint64
+}
+
+func (s *floatCarry) refill(now int64) {
+	last := s.last.Load()
+	elapsed := now - last
+	if elapsed <= 0 {
+		return
+	}
+	newTokens := uint64(float64(s.rate) * float64(elapsed) / 1e9)
+	if newTokens == 0 {
+		return
+	}
+	spent := min(int64(float64(newTokens)*1e9/float64(s.rate)), elapsed)
+	if s.last.CompareAndSwap(last, last+spent) {
+		s.tokens.Add(newTokens)
+	}
+}
+
+func (s *floatCarry) load() uint64 { return s.tokens.Load() }
+
+type integerCarry struct {
+	rate   uint64
+	last   atomic.Int64
+	tokens atomic.Uint64
+}
+
+func (s *integerCarry) refill(now int64) {
+	last := s.last.Load()
+	elapsed := now - last
+	if elapsed <= 0 {
+		return
+	}
+	hi, lo := bits.Mul64(s.rate, uint64(elapsed))
+	newTokens, _ := bits.Div64(hi, lo, uint64(time.Second))
+	if newTokens == 0 {
+		return
+	}
+	hi, lo = bits.Mul64(newTokens, uint64(time.Second))
+	spent, rem := bits.Div64(hi, lo, s.rate)
+	if rem > 0 {
+		spent++
+	}
+	if s.last.CompareAndSwap(last, last+int64(spent)) {
+		s.tokens.Add(newTokens)
+	}
+}
+
+func (s *integerCarry) load() uint64 { return s.tokens.Load() }
+
+type accumulator struct {
+	rate       uint64
+	mu         sync.Mutex
+	last       int64
+	nanoTokens uint64
+	tokens     uint64
+}
+
+func (s *accumulator) refill(now int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now <= s.last {
+		return
+	}
+	s.nanoTokens += s.rate * uint64(now-s.last)
+	s.last = now
+	s.tokens += s.nanoTokens / uint64(time.Second)
+	s.nanoTokens %= uint64(time.Second)
+}
+
+func (s *accumulator) load() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens
+}
+
+type atomicAdd struct {
+	rate       uint64
+	last       atomic.Int64
+	nanoTokens atomic.Uint64
+}
+
+func (s *atomicAdd) refill(now int64) {
+	last := s.last.Load()
+	if now <= last || !s.last.CompareAndSwap(last, now) {
+		return
+	}
+	s.nanoTokens.Add(s.rate * uint64(now-last))
+}
+
+func (s *atomicAdd) load() uint64 { return s.nanoTokens.Load() / uint64(time.Second) }
+
+// - is ATLimiter.refillAt itself, so regressions of the real code show up next to the strategies.
+// Generated tokens are drained after every refill, so capacity doesn't clamp them.
+type limiterRefill struct {
+	rate    uint64
+	limiter *ATLimiter
+	start   int64
+	tokens  atomic.Uint64
+}
+
+func newLimiterRefill(rate uint64) *limiterRefill {
+	limiter := NewLimiter(rate, 1.0, WithInitialTokens(0))
+	return &limiterRefill{rate: rate, limiter: limiter, start: limiter.store.LoadRefill()}
+}
+
+func (s *limiterRefill) refill(now int64) {
+	s.limiter.refillAt(s.start+now, s.rate)
+	s.tokens.Add(s.limiter.removeTokens(math.MaxUint64))
+}
+
+func (s *limiterRefill) load() uint64 { return s.tokens.Load() }
+
+var refillStrategies = []struct {
+	name string
+	make func(rate uint64) refillStrategy
+}{
+	{"floatNaive", func(rate uint64) refillStrategy { return &floatNaive{rate: rate} }},
+	{"floatCarry", func(rate uint64) refillStrategy { return &floatCarry{rate: rate} }},
+	{"integer", func(rate uint64) refillStrategy { return &integerCarry{rate: rate} }},
+	{"accumulator", func(rate uint64) refillStrategy { return &accumulator{rate: rate} }},
+	{"atomicAdd", func(rate uint64) refillStrategy { return &atomicAdd{rate: rate} }},
+	{"limiter", func(rate uint64) refillStrategy { return newLimiterRefill(rate) }},
+}
+
+// Rate isn't a divisor of a second, so every strategy faces fractional tokens.
+const benchRefillRate = 333
+
+// - calls refill with fake time advancing by step and reports tokens lost against exact generation.
+func benchmarkRefill(b *testing.B, step time.Duration) {
+	for _, strategy := range refillStrategies {
+		b.Run(strategy.name, func(b *testing.B) {
+			s := strategy.make(benchRefillRate)
+			var now int64
+			for b.Loop() {
+				now += int64(step)
+				s.refill(now)
+			}
+
+			hi, lo := bits.Mul64(benchRefillRate, uint64(now))
+			expected, _ := bits.Div64(hi, lo, uint64(time.Second))
+			b.ReportMetric(float64(int64(expected)-int64(s.load())), "lost-tokens")
+		})
+	}
+}
+
+// Each call generates several tokens and a fraction.
+func Benchmark_Refill_LowFrequency(b *testing.B) {
+	benchmarkRefill(b, 10*time.Millisecond)
+}
+
+// Most calls generate only a fraction of a token.
+func Benchmark_Refill_HighFrequency(b *testing.B) {
+	benchmarkRefill(b, time.Microsecond)
+}
+
+func Benchmark_Refill_Parallel(b *testing.B) {
+	for _, strategy := range refillStrategies {
+		b.Run(strategy.name, func(b *testing.B) {
+			s := strategy.make(benchRefillRate)
+			var clock atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					s.refill(clock.Add(int64(time.Microsecond)))
+				}
+			})
+		})
+	}
+}
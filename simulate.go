@@ -0,0 +1,40 @@
+package atlimiter
+
+import "time"
+
+// - is an outcome of replaying events through a limiter.
+type SimResult struct {
+	// Quantity of allowed events
+	Allowed uint64
+	// Quantity of denied events
+	Denied uint64
+	// Times of denied events in replay order
+	Denials []time.Time
+}
+
+// - replays request timestamps through a fresh limiter built from cfg and reports its decisions.
+//
+// It's designed for capacity planning: captured production traffic can be replayed offline
+// against different configurations. Simulation is deterministic, the real clock isn't used:
+// the limiter is created at the first event and refilled by AllowAtCost at each event.
+// Events are expected in chronological order.
+func Simulate(events []time.Time, cfg LimiterConfig) SimResult {
+	var result SimResult
+	if len(events) == 0 {
+		return result
+	}
+
+	start := events[0]
+	limiter := NewLimiter(cfg.MaxRPS, cfg.CapacityFactor, WithClock(func() time.Time { return start }))
+
+	for _, event := range events {
+		if limiter.AllowAtCost(event, 1) {
+			result.Allowed++
+		} else {
+			result.Denied++
+			result.Denials = append(result.Denials, event)
+		}
+	}
+
+	return result
+}
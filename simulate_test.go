@@ -0,0 +1,39 @@
+package atlimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Burst of 30 events at start, then 10 events spread over the next second.
+	var events []time.Time
+	for range 30 {
+		events = append(events, start)
+	}
+	for i := range 10 {
+		events = append(events, start.Add(time.Duration(i+1)*100*time.Millisecond))
+	}
+
+	strict := Simulate(events, LimiterConfig{MaxRPS: 10, CapacityFactor: 1.0})
+	if strict.Allowed != 20 || strict.Denied != 20 {
+		t.Errorf("Strict config: expected 20 allowed and 20 denied, got %d and %d", strict.Allowed, strict.Denied)
+	}
+	if len(strict.Denials) != 20 || !strict.Denials[0].Equal(start) {
+		t.Errorf("Strict config: expected 20 denials starting at %v, got %v", start, strict.Denials)
+	}
+
+	bursty := Simulate(events, LimiterConfig{MaxRPS: 10, CapacityFactor: 3.0})
+	if bursty.Allowed != 40 || bursty.Denied != 0 {
+		t.Errorf("Bursty config: expected 40 allowed and 0 denied, got %d and %d", bursty.Allowed, bursty.Denied)
+	}
+
+	if again := Simulate(events, LimiterConfig{MaxRPS: 10, CapacityFactor: 1.0}); again.Allowed != strict.Allowed {
+		t.Errorf("Simulation should be deterministic, got %d and %d allowed", strict.Allowed, again.Allowed)
+	}
+	if empty := Simulate(nil, LimiterConfig{MaxRPS: 10}); empty.Allowed != 0 || empty.Denied != 0 {
+		t.Errorf("Expected empty result without events, got %+v", empty)
+	}
+}
@@ -0,0 +1,48 @@
+package atlimiter
+
+import "sync/atomic"
+
+// - is a storage of limiter's tokens and last refill time.
+//
+// Limiter's algorithm relies on compare-and-swap contract: CAS methods must atomically replace
+// value only if it still equals old and report whether replacement happened. Implementations
+// backed by shared memory (e.g. memory-mapped file) let several processes share one bucket.
+type TokenStore interface {
+	// LoadTokens returns current quantity of tokens.
+	LoadTokens() uint64
+	// CASTokens replaces quantity of tokens with new if it equals old.
+	CASTokens(old, new uint64) bool
+	// LoadRefill returns last refill time in unix nanoseconds.
+	LoadRefill() int64
+	// CASRefill replaces last refill time with new if it equals old.
+	CASRefill(old, new int64) bool
+}
+
+// - is a default TokenStore based on in-struct atomic variables.
+type localStore struct {
+	// The current number of tokens in the token container.
+	// Tokens are generated every second and spent on request at a one-to-one ratio.
+	tokens atomic.Uint64
+	// Last refill - is a previous token replenishment in unix nanoseconds
+	lastRefill atomic.Int64
+}
+
+// - returns current quantity of tokens.
+func (s *localStore) LoadTokens() uint64 {
+	return s.tokens.Load()
+}
+
+// - compares and swaps quantity of tokens.
+func (s *localStore) CASTokens(old, new uint64) bool {
+	return s.tokens.CompareAndSwap(old, new)
+}
+
+// - returns last refill time in unix nanoseconds.
+func (s *localStore) LoadRefill() int64 {
+	return s.lastRefill.Load()
+}
+
+// - compares and swaps last refill time.
+func (s *localStore) CASRefill(old, new int64) bool {
+	return s.lastRefill.CompareAndSwap(old, new)
+}
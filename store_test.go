@@ -0,0 +1,42 @@
+package atlimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultTokenStore(t *testing.T) {
+	limiter := NewLimiter(10, 2.0)
+
+	if limiter.store != &limiter.local {
+		t.Fatal("Default store should be in-struct local store")
+	}
+	if !limiter.TryAllow(15) {
+		t.Error("Should allow 15 tokens")
+	}
+	if available := limiter.local.tokens.Load(); available != 5 {
+		t.Errorf("Expected 5 tokens in local store, got %d", available)
+	}
+}
+
+func TestSharedTokenStore(t *testing.T) {
+	shared := &localStore{}
+	shared.tokens.Store(10)
+	shared.lastRefill.Store(time.Now().UnixNano())
+
+	first := NewLimiter(10, 1.0, WithTokenStore(shared))
+	second := NewLimiter(10, 1.0, WithTokenStore(shared))
+
+	if !first.TryAllow(6) {
+		t.Error("First limiter should allow 6 tokens")
+	}
+	if second.TryAllow(6) {
+		t.Error("Second limiter should see tokens spent by the first one")
+	}
+	if !second.TryAllow(4) {
+		t.Error("Second limiter should allow remaining 4 tokens")
+	}
+	if first.Allow() {
+		t.Error("Shared bucket should be empty")
+	}
+}
@@ -0,0 +1,56 @@
+package atlimiter
+
+import (
+	"context"
+	"time"
+)
+
+// - returns channel that emits current time at the limiter's rate, i.e. every 1/maxRPS.
+//
+// Unlike time.Ticker interval follows the limiter: after SetMaxRPS next emission is re-spaced
+// by the new rate. Emissions are never closer than 1/maxRPS regardless of capacity, so there are no bursts:
+// if receiver is slow, the next emission is scheduled from the moment the previous one was received.
+// Unlimited limiter (maxRPS equals zero) emits as fast as receiver reads.
+// Ticker only paces the caller and doesn't take tokens from the limiter. Time is measured by the clock
+// and timers set by WithClock and WithTimer.
+// Channel is closed when ctx is done or limiter is closed.
+func (r *ATLimiter) Ticker(ctx context.Context) <-chan time.Time {
+	ticks := make(chan time.Time)
+
+	go func() {
+		defer close(ticks)
+
+		last := r.now()
+		for {
+			reconfigured := *r.reconfigured.Load()
+
+			var delay time.Duration
+			if maxRPS := r.GetMaxRPS(); maxRPS != 0 {
+				delay = time.Duration(float64(time.Second)/float64(maxRPS)) - r.now().Sub(last)
+			}
+
+			if delay > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-r.done:
+					return
+				case <-reconfigured:
+					continue
+				case <-r.after(delay):
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.done:
+				return
+			case ticks <- r.now():
+				last = r.now()
+			}
+		}
+	}()
+
+	return ticks
+}
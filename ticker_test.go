@@ -0,0 +1,54 @@
+package atlimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTicker(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(100, 10.0, WithClock(clock.Now), WithTimer(clock.After))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticks := limiter.Ticker(ctx)
+
+	previous := clock.Now()
+	for range 3 {
+		clock.BlockUntil(1)
+		clock.Advance(9 * time.Millisecond)
+		select {
+		case <-ticks:
+			t.Fatal("Ticker should not emit before 1/maxRPS regardless of capacity")
+		default:
+		}
+
+		clock.Advance(time.Millisecond)
+		if tick := <-ticks; tick.Sub(previous) != 10*time.Millisecond {
+			t.Errorf("Expected spacing 10ms at 100 RPS, got %v", tick.Sub(previous))
+		} else {
+			previous = tick
+		}
+	}
+
+	// Timer of the old rate is abandoned but still pending, the new one is created after SetMaxRPS.
+	clock.BlockUntil(1)
+	limiter.SetMaxRPS(25, 1.0)
+	clock.BlockUntil(2)
+	clock.Advance(10 * time.Millisecond)
+	select {
+	case <-ticks:
+		t.Fatal("Ticker should be re-spaced by the new rate after SetMaxRPS")
+	default:
+	}
+
+	clock.Advance(30 * time.Millisecond)
+	if tick := <-ticks; tick.Sub(previous) != 40*time.Millisecond {
+		t.Errorf("Expected spacing 40ms after SetMaxRPS(25), got %v", tick.Sub(previous))
+	}
+
+	cancel()
+	for range ticks {
+	}
+}
@@ -0,0 +1,29 @@
+package atlimiter
+
+// - moves up to n tokens from one limiter to another and returns quantity actually moved.
+//
+// Tokens are taken from `from` and added to `to` clamped to its capacity.
+// Remainder that didn't fit into `to` is returned back to `from`, so budget isn't duplicated.
+// Each step is atomic, but the whole transfer isn't: concurrent requests may observe
+// taken tokens in neither limiter for a moment. If `from` is refilled to full capacity
+// in the meantime, returned remainder is clamped by its capacity.
+func TransferTokens(from, to *ATLimiter, n uint64) uint64 {
+	if from == to || n == 0 {
+		return 0
+	}
+
+	from.calculateTokenRefill()
+	taken := from.removeTokens(n)
+	if taken == 0 {
+		return 0
+	}
+
+	to.calculateTokenRefill()
+	moved := to.addTokens(taken)
+
+	if remainder := taken - moved; remainder > 0 {
+		from.addTokens(remainder)
+	}
+
+	return moved
+}
@@ -0,0 +1,59 @@
+package atlimiter
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTransferTokens(t *testing.T) {
+	from := NewLimiter(100, 1.0)
+	to := NewLimiter(100, 1.0)
+	to.TryAllow(30)
+
+	if moved := TransferTokens(from, to, 20); moved != 20 {
+		t.Errorf("Expected 20 moved tokens, got %d", moved)
+	}
+	if available := from.Available(); available != 80 {
+		t.Errorf("Expected 80 tokens in source, got %d", available)
+	}
+	if available := to.Available(); available != 90 {
+		t.Errorf("Expected 90 tokens in destination, got %d", available)
+	}
+
+	if moved := TransferTokens(from, to, 50); moved != 10 {
+		t.Errorf("Expected 10 moved tokens clamped by capacity, got %d", moved)
+	}
+	if available := from.Available(); available != 70 {
+		t.Errorf("Remainder should return to source, expected 70 tokens, got %d", available)
+	}
+	if available := to.Available(); available != 100 {
+		t.Errorf("Expected full destination, got %d", available)
+	}
+}
+
+func TestTransferTokensConcurrent(t *testing.T) {
+	first := NewLimiter(1, 1000.0)
+	second := NewLimiter(1, 1000.0)
+	first.TryAllow(500)
+	second.TryAllow(500)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				if i%2 == 0 {
+					TransferTokens(first, second, 3)
+				} else {
+					TransferTokens(second, first, 3)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total := first.store.LoadTokens() + second.store.LoadTokens(); total < 1000 || total > 1002 {
+		t.Errorf("Transfers should conserve tokens, expected about 1000 in total, got %d", total)
+	}
+}
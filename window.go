@@ -0,0 +1,50 @@
+package atlimiter
+
+import "sync/atomic"
+
+// - is a counter of tokens granted during current second of limiter's clock.
+//
+// Windows are aligned to whole seconds. Count of previous window is kept when window rotates.
+type grantWindow struct {
+	// Start of current window in unix nanoseconds
+	start atomic.Int64
+	// Tokens granted during current window
+	count atomic.Uint64
+	// Tokens granted during previous window
+	previous atomic.Uint64
+}
+
+// - is a private method of grantWindow that rotates window if now belongs to a later second.
+//
+// Rotation isn't atomic with concurrent additions, grants racing with it may land in either window.
+func (w *grantWindow) rotate(now int64) {
+	windowStart := now - now%1e9
+	start := w.start.Load()
+	if start >= windowStart || !w.start.CompareAndSwap(start, windowStart) {
+		return
+	}
+
+	count := w.count.Swap(0)
+	if start != windowStart-1e9 {
+		count = 0
+	}
+	w.previous.Store(count)
+}
+
+// - adds N = tokensCount of granted tokens to window of now and returns new count of the window.
+func (w *grantWindow) add(now int64, tokensCount uint64) uint64 {
+	w.rotate(now)
+	return w.count.Add(tokensCount)
+}
+
+// - returns tokens granted during second of now.
+func (w *grantWindow) current(now int64) uint64 {
+	w.rotate(now)
+	return w.count.Load()
+}
+
+// - returns tokens granted during second before the second of now.
+func (w *grantWindow) last(now int64) uint64 {
+	w.rotate(now)
+	return w.previous.Load()
+}
@@ -0,0 +1,29 @@
+package atlimiter
+
+import "testing"
+
+func TestGrantWindow(t *testing.T) {
+	var window grantWindow
+	second := int64(1_700_000_000) * 1e9
+
+	window.add(second, 3)
+	window.add(second+5e8, 4)
+	if count := window.current(second + 999_999_999); count != 7 {
+		t.Errorf("Expected 7 tokens in current window, got %d", count)
+	}
+
+	window.add(second+1e9, 1)
+	if count := window.current(second + 1e9); count != 1 {
+		t.Errorf("Expected 1 token after rotation, got %d", count)
+	}
+	if previous := window.previous.Load(); previous != 7 {
+		t.Errorf("Expected 7 tokens in previous window, got %d", previous)
+	}
+
+	if count := window.current(second + 5e9); count != 0 {
+		t.Errorf("Expected empty window after idle seconds, got %d", count)
+	}
+	if previous := window.previous.Load(); previous != 0 {
+		t.Errorf("Previous window should be empty when it isn't adjacent, got %d", previous)
+	}
+}